@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
+)
+
+// AdminSnippets is the moderators' landing page. It lists removed snippets so
+// they can be restored; snippets are removed from their own page.
+func (app *App) AdminSnippets(w http.ResponseWriter, r *http.Request) {
+	snippets, err := app.Database.RemovedSnippets(r.Context())
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	session := app.Sessions.Load(r)
+	flash, err := session.PopString(w, "flash")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.RenderHTML(w, r, "adminsnippets.html", &HTMLData{
+		Flash:    flash,
+		Snippets: snippets,
+	})
+}
+
+func (app *App) RemoveSnippet(w http.ResponseWriter, r *http.Request) {
+	app.moderate(w, r, "/admin", printer(r).T("The snippet was removed."),
+		func(actorID, id int) error {
+			return app.Database.RemoveSnippet(r.Context(), actorID, id)
+		})
+}
+
+func (app *App) RestoreSnippet(w http.ResponseWriter, r *http.Request) {
+	app.moderate(w, r, "/admin", printer(r).T("The snippet was restored."),
+		func(actorID, id int) error {
+			return app.Database.RestoreSnippet(r.Context(), actorID, id)
+		})
+}
+
+// AdminUsers lists the latest users, or those matching the ?q= search.
+func (app *App) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	users, err := app.Database.SearchUsers(r.Context(), query)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	session := app.Sessions.Load(r)
+	flash, err := session.PopString(w, "flash")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.RenderHTML(w, r, "adminusers.html", &HTMLData{
+		Flash: flash,
+		Query: query,
+		Users: users,
+	})
+}
+
+func (app *App) DisableUser(w http.ResponseWriter, r *http.Request) {
+	app.moderate(w, r, "/admin/users", printer(r).T("The account was disabled."),
+		func(actorID, id int) error {
+			// Locking yourself out is never what you meant to do.
+			if actorID == id {
+				return errSelfModeration
+			}
+			return app.Database.SetUserDisabled(r.Context(), actorID, id, true)
+		})
+}
+
+func (app *App) EnableUser(w http.ResponseWriter, r *http.Request) {
+	app.moderate(w, r, "/admin/users", printer(r).T("The account was enabled."),
+		func(actorID, id int) error {
+			return app.Database.SetUserDisabled(r.Context(), actorID, id, false)
+		})
+}
+
+func (app *App) ChangeUserRole(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	role := models.Role(r.PostForm.Get("role"))
+	if !role.Valid() {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	p := printer(r)
+	msg := p.T("The user is now a %s.", p.T(string(role)))
+	app.moderate(w, r, "/admin/users", msg,
+		func(actorID, id int) error {
+			if actorID == id {
+				return errSelfModeration
+			}
+			return app.Database.SetUserRole(r.Context(), actorID, id, role)
+		})
+}
+
+// AdminAuditLog shows the most recent moderation actions.
+func (app *App) AdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	log, err := app.Database.LatestAuditLog(r.Context())
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.RenderHTML(w, r, "adminaudit.html", &HTMLData{AuditLog: log})
+}
+
+// moderate holds the plumbing shared by the moderation actions: it reads the
+// :id from the URL, runs action on behalf of the current user, and redirects
+// back to the given page with a flash message describing what happened.
+func (app *App) moderate(w http.ResponseWriter, r *http.Request, redirect, msg string,
+	action func(actorID, id int) error) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.NotFound(w)
+		return
+	}
+	// RequireRole has already checked there is a current user.
+	actor, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = action(actor.ID, id)
+	if err == models.ErrNoRecord {
+		msg = printer(r).T("Nothing was changed.")
+	} else if err == errSelfModeration {
+		msg = printer(r).T("You can't do that to your own account.")
+	} else if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	err = session.PutString(w, "flash", msg)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
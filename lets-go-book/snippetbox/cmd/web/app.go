@@ -1,20 +1,42 @@
 package main
 
 import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
 	"github.com/alexedwards/scs"
+	"github.com/noelruault/lets-go/snippetbox/pkg/blob"
+	"github.com/noelruault/lets-go/snippetbox/pkg/forms"
+	"github.com/noelruault/lets-go/snippetbox/pkg/i18n"
 	"github.com/noelruault/lets-go/snippetbox/pkg/models"
+	"github.com/noelruault/lets-go/snippetbox/pkg/sso"
 )
 
 // Define an App struct to hold the application-wide dependencies and configuration
-// settings for our web application. For now we'll only include a HTMLDir field
-// for the path to the HTML templates directory, but we'll add more to it as our
-// build progresses.
+// settings for our web application.
 type App struct {
-	Addr      string // Add an Addr field
-	Database  *models.Database
-	HTMLDir   string
-	Sessions  *scs.Manager
-	StaticDir string
-	TLSCert   string // Add a TLSCert field
-	TLSKey    string // Add a TLSKey field
+	Addr           string        // Add an Addr field
+	Blobs          blob.Store    // Where attachments are kept.
+	Catalog        *i18n.Catalog // The interface translations.
+	CSPPolicy      string        // Content-Security-Policy, see DefaultCSP. Empty for none.
+	CSPReportOnly  bool          // Report violations of CSPPolicy without enforcing it.
+	Database       *models.Database
+	Logger         *slog.Logger
+	Metrics        *Metrics
+	MetricsAddr    string // If set, /metrics is served on this address instead.
+	PasswordPolicy *forms.PasswordPolicy
+	Sessions       *scs.Manager
+	SSO            *sso.Provider // nil unless single sign-on is configured.
+	Static         *Assets       // The static assets, served under /static/.
+	Templates      *templateCache
+	TLSCert        string // Add a TLSCert field
+	TLSKey         string // Add a TLSKey field
+	UploadPolicy   *forms.UploadPolicy
+	// ShutdownDelay is how long to keep serving, with /readyz failing, after
+	// being asked to stop and before refusing new connections. It gives load
+	// balancers time to notice.
+	ShutdownDelay time.Duration
+
+	shuttingDown atomic.Bool
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/blob"
+	"github.com/noelruault/lets-go/snippetbox/pkg/forms"
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
+)
+
+// maxUploadBody is the most a new snippet's request body can be: every
+// attachment at the size limit, plus a megabyte for the rest of the form.
+func (app *App) maxUploadBody() int64 {
+	maxSize := forms.DefaultUploadPolicy.MaxSize
+	if app.UploadPolicy != nil && app.UploadPolicy.MaxSize != 0 {
+		maxSize = app.UploadPolicy.MaxSize
+	}
+	return models.MaxAttachments*maxSize + 1<<20
+}
+
+// minTransferRate is the slowest, in bytes per second, we expect a file to be
+// sent to or from a client or the blob store: about 1 Mbit/s.
+const minTransferRate = 128 << 10
+
+// transferTime is how long n bytes may take at minTransferRate, plus the 10
+// seconds any request gets.
+func transferTime(n int64) time.Duration {
+	return 10*time.Second + time.Duration(n/minTransferRate)*time.Second
+}
+
+// extendDeadlines moves this request's read and write deadlines past the
+// server's ReadTimeout and WriteTimeout, which are sized for pages, not files.
+// A ResponseWriter that can't (like httptest's) keeps the server's timeouts.
+func extendDeadlines(w http.ResponseWriter, read, write time.Time) {
+	rc := http.NewResponseController(w)
+	if !read.IsZero() {
+		rc.SetReadDeadline(read)
+	}
+	rc.SetWriteDeadline(write)
+}
+
+// saveAttachments puts the uploaded files (already checked against the
+// upload policy) in the blob store. A file that's there already, from this
+// snippet or another, isn't stored twice.
+func (app *App) saveAttachments(r *http.Request, uploads []*multipart.FileHeader) ([]models.Attachment, error) {
+	var attachments []models.Attachment
+	for _, fh := range uploads {
+		contentType, err := forms.UploadType(fh)
+		if err != nil {
+			return nil, err
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return nil, err
+		}
+		key, size, err := blob.Save(r.Context(), app.Blobs, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, models.Attachment{
+			Name:        models.AttachmentName(fh.Filename),
+			ContentType: contentType,
+			Size:        size,
+			Key:         key,
+		})
+	}
+	return attachments, nil
+}
+
+// ShowAttachment downloads one of a snippet's attachments. It's always sent as
+// a download, never shown inline, and under a sandboxing CSP in case a browser
+// renders it anyway, so an uploaded file can't run as part of the site.
+func (app *App) ShowAttachment(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.snippet(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get(":attachment"))
+	if err != nil {
+		app.NotFound(w)
+		return
+	}
+	attachment := snippet.Attachment(id)
+	if attachment == nil {
+		app.NotFound(w)
+		return
+	}
+	// Give the download time to arrive, as a slow client would otherwise have
+	// it cut off by the server's WriteTimeout.
+	extendDeadlines(w, time.Time{}, time.Now().Add(transferTime(attachment.Size)))
+
+	rc, err := app.Blobs.Open(r.Context(), attachment.Key)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	w.Header().Set("Content-Security-Policy", "sandbox; default-src 'none'")
+	w.Header().Del("Content-Security-Policy-Report-Only")
+	// From here on the file is streamed, so a failure can only be logged.
+	_, err = io.Copy(w, rc)
+	if err != nil {
+		app.requestLogger(r).Error("sending attachment", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/forms"
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
+)
+
+// Collections lists the latest public collections and, for a logged in user,
+// their own collections with a form to start a new one.
+func (app *App) Collections(w http.ResponseWriter, r *http.Request) {
+	session := app.Sessions.Load(r)
+	flash, err := session.PopString(w, "flash")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	// New collections are private until their owner says otherwise.
+	form := forms.New(url.Values{"visibility": {string(models.Private)}}, printer(r))
+	app.renderCollections(w, r, &HTMLData{Flash: flash, Form: form})
+}
+
+func (app *App) CreateCollection(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	form := forms.New(r.PostForm, printer(r))
+	var collection forms.Collection
+	err = form.Decode(&collection)
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	if !collection.Valid(form) {
+		app.renderCollections(w, r, &HTMLData{Form: form})
+		return
+	}
+
+	// RequireLogin has already checked there is a current user.
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	id, err := app.Database.InsertCollection(r.Context(), user.ID, collection.Name,
+		models.Visibility(collection.Visibility))
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.redirectWithFlash(w, r, fmt.Sprintf("/collection/%d", id),
+		printer(r).T("Your collection was created. Add snippets to it from their pages."))
+}
+
+// renderCollections fills in the lists for collectionspage.html and renders
+// it with data.
+func (app *App) renderCollections(w http.ResponseWriter, r *http.Request, data *HTMLData) {
+	var err error
+	data.PublicCollections, err = app.Database.PublicCollections(r.Context(), 20)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	if user != nil {
+		data.Collections, err = app.Database.UserCollections(r.Context(), user.ID)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+	}
+	app.RenderHTML(w, r, "collectionspage.html", data)
+}
+
+// ShowCollection shows a collection's snippets in order, in full, so that a
+// collection reads as one document. Its owner also gets the controls to
+// change it.
+func (app *App) ShowCollection(w http.ResponseWriter, r *http.Request) {
+	collection, user, ok := app.collection(w, r, r.URL.Query().Get(":id"))
+	if !ok {
+		return
+	}
+	if !collection.VisibleTo(user) {
+		// Don't give away that a private collection exists.
+		app.NotFound(w)
+		return
+	}
+	session := app.Sessions.Load(r)
+	flash, err := session.PopString(w, "flash")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	form := forms.New(url.Values{
+		"name":       {collection.Name},
+		"visibility": {string(collection.Visibility)},
+	}, printer(r))
+	app.RenderHTML(w, r, "collectionpage.html", &HTMLData{
+		Collection: collection,
+		Flash:      flash,
+		Form:       form,
+	})
+}
+
+// UpdateCollection renames a collection or changes who can see it.
+func (app *App) UpdateCollection(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownCollection(w, r, r.URL.Query().Get(":id"))
+	if !ok {
+		return
+	}
+	form := forms.New(r.PostForm, printer(r))
+	var update forms.Collection
+	err := form.Decode(&update)
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	if !update.Valid(form) {
+		app.RenderHTML(w, r, "collectionpage.html", &HTMLData{
+			Collection: collection,
+			Form:       form,
+		})
+		return
+	}
+	err = app.Database.UpdateCollection(r.Context(), collection.ID, update.Name,
+		models.Visibility(update.Visibility))
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.redirectWithFlash(w, r, fmt.Sprintf("/collection/%d", collection.ID),
+		printer(r).T("Your collection was saved."))
+}
+
+func (app *App) DeleteCollection(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownCollection(w, r, r.URL.Query().Get(":id"))
+	if !ok {
+		return
+	}
+	err := app.Database.DeleteCollection(r.Context(), collection.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.redirectWithFlash(w, r, "/collections",
+		printer(r).T("The collection %q was deleted.", collection.Name))
+}
+
+// AddToCollection adds the snippet in the URL to the end of the collection in
+// the collection_id form field, and goes back to the snippet's page.
+//
+// Any snippet its owner can see can go in a collection. Snippets don't have
+// owners or visibility of their own yet, so for now that is every snippet
+// that hasn't expired or been removed.
+func (app *App) AddToCollection(w http.ResponseWriter, r *http.Request) {
+	snippetID, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || snippetID < 1 {
+		app.NotFound(w)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	collection, ok := app.ownCollection(w, r, r.PostForm.Get("collection_id"))
+	if !ok {
+		return
+	}
+	err = app.Database.AddToCollection(r.Context(), collection.ID, snippetID)
+	if err == models.ErrNoRecord {
+		app.NotFound(w)
+		return
+	} else if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.redirectWithFlash(w, r, fmt.Sprintf("/snippet/%d", snippetID),
+		printer(r).T("The snippet was added to %q.", collection.Name))
+}
+
+// EditCollectionSnippet moves the snippet in the URL up or down a collection,
+// or takes it out, as the action form field says.
+func (app *App) EditCollectionSnippet(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownCollection(w, r, r.URL.Query().Get(":id"))
+	if !ok {
+		return
+	}
+	snippetID, err := strconv.Atoi(r.URL.Query().Get(":snippet"))
+	if err != nil || snippetID < 1 {
+		app.NotFound(w)
+		return
+	}
+	switch r.PostForm.Get("action") {
+	case "up":
+		err = app.Database.MoveInCollection(r.Context(), collection.ID, snippetID, -1)
+	case "down":
+		err = app.Database.MoveInCollection(r.Context(), collection.ID, snippetID, 1)
+	case "remove":
+		err = app.Database.RemoveFromCollection(r.Context(), collection.ID, snippetID)
+	default:
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	// The snippet may have been taken out in another tab; there's nothing
+	// left to do either way.
+	if err != nil && err != models.ErrNoRecord {
+		app.ServerError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/collection/%d", collection.ID), http.StatusSeeOther)
+}
+
+// collection loads the collection with the given ID, along with the current
+// user (nil if nobody is logged in). If it returns false it has already sent
+// the response.
+func (app *App) collection(w http.ResponseWriter, r *http.Request, rawID string) (*models.Collection, *models.User, bool) {
+	id, err := strconv.Atoi(rawID)
+	if err != nil || id < 1 {
+		app.NotFound(w)
+		return nil, nil, false
+	}
+	collection, err := app.Database.GetCollection(r.Context(), id)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return nil, nil, false
+	}
+	if collection == nil {
+		app.NotFound(w)
+		return nil, nil, false
+	}
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return nil, nil, false
+	}
+	return collection, user, true
+}
+
+// ownCollection is collection for the handlers that change one: it parses
+// the form, and only lets the collection's owner through.
+func (app *App) ownCollection(w http.ResponseWriter, r *http.Request, rawID string) (*models.Collection, bool) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return nil, false
+	}
+	collection, user, ok := app.collection(w, r, rawID)
+	if !ok {
+		return nil, false
+	}
+	if !collection.OwnedBy(user) {
+		if collection.VisibleTo(user) {
+			app.ClientError(w, http.StatusForbidden)
+		} else {
+			app.NotFound(w)
+		}
+		return nil, false
+	}
+	return collection, true
+}
+
+// redirectWithFlash sets the flash message and redirects to the given path.
+func (app *App) redirectWithFlash(w http.ResponseWriter, r *http.Request, path, msg string) {
+	session := app.Sessions.Load(r)
+	err := session.PutString(w, "flash", msg)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, path, http.StatusSeeOther)
+}
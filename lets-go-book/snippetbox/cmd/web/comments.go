@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/forms"
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
+)
+
+// CreateComment posts a comment on the snippet in the URL, or a reply to one
+// of its comments.
+func (app *App) CreateComment(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.snippet(w, r)
+	if !ok {
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	form := forms.New(r.PostForm, printer(r))
+	comment := forms.Comment{Lines: len(snippet.Lines())}
+	err = form.Decode(&comment)
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	// Replies have to be to a comment on the same snippet that is still
+	// there to reply to.
+	if comment.Parent != 0 {
+		parent, err := app.Database.GetComment(r.Context(), comment.Parent)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+		form.Check(parent != nil && parent.SnippetID == snippet.ID && parent.Live(), "parent_id",
+			printer(r).T("The comment you are replying to has been deleted"))
+	}
+	if !comment.Valid(form) {
+		app.renderSnippet(w, r, snippet, &HTMLData{Form: form})
+		return
+	}
+
+	// RequireLogin has already checked there is a current user.
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	id, err := app.Database.InsertComment(r.Context(), snippet.ID, user.ID, comment.Parent, comment.Line,
+		comment.Body)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, commentURL(snippet.ID, id, snippet.E2E), http.StatusSeeOther)
+}
+
+// commentURL is the address of a comment: its snippet's page, scrolled to it.
+// An end-to-end encrypted snippet's page has its key in the fragment instead,
+// so the address has none, and the browser keeps the one it's at after a
+// redirect.
+func commentURL(snippetID, commentID int, e2e bool) string {
+	if e2e {
+		return fmt.Sprintf("/snippet/%d", snippetID)
+	}
+	return fmt.Sprintf("/snippet/%d#comment-%d", snippetID, commentID)
+}
+
+// EditComment shows the form for the author of a comment to change it.
+func (app *App) EditComment(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.ownComment(w, r)
+	if !ok {
+		return
+	}
+	form := forms.New(url.Values{"body": {comment.Body}}, printer(r))
+	app.RenderHTML(w, r, "commentpage.html", &HTMLData{Comment: comment, Form: form})
+}
+
+func (app *App) UpdateComment(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.ownComment(w, r)
+	if !ok {
+		return
+	}
+	form := forms.New(r.PostForm, printer(r))
+	var update forms.Comment
+	err := form.Decode(&update)
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	// Only the text can change; the line and thread stay as they were.
+	update.Line, update.Parent = 0, 0
+	if !update.Valid(form) {
+		app.RenderHTML(w, r, "commentpage.html", &HTMLData{Comment: comment, Form: form})
+		return
+	}
+	err = app.Database.UpdateComment(r.Context(), comment.ID, update.Body)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, commentURL(comment.SnippetID, comment.ID, comment.SnippetE2E), http.StatusSeeOther)
+}
+
+func (app *App) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.ownComment(w, r)
+	if !ok {
+		return
+	}
+	err := app.Database.DeleteComment(r.Context(), comment.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.redirectWithFlash(w, r, fmt.Sprintf("/snippet/%d", comment.SnippetID),
+		printer(r).T("Your comment was deleted."))
+}
+
+// RemoveComment is for moderators, and goes back to the snippet rather than
+// the admin pages.
+func (app *App) RemoveComment(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.comment(w, r)
+	if !ok {
+		return
+	}
+	app.moderate(w, r, fmt.Sprintf("/snippet/%d", comment.SnippetID), printer(r).T("The comment was removed."),
+		func(actorID, id int) error {
+			return app.Database.RemoveComment(r.Context(), actorID, id)
+		})
+}
+
+// comment loads the comment with the :id in the URL. If it returns false it
+// has already sent the response.
+func (app *App) comment(w http.ResponseWriter, r *http.Request) (*models.Comment, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.NotFound(w)
+		return nil, false
+	}
+	comment, err := app.Database.GetComment(r.Context(), id)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return nil, false
+	}
+	if comment == nil {
+		app.NotFound(w)
+		return nil, false
+	}
+	return comment, true
+}
+
+// ownComment is comment for the handlers that change one: it parses the
+// form, and only lets the comment's author through, while it's still live.
+func (app *App) ownComment(w http.ResponseWriter, r *http.Request) (*models.Comment, bool) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return nil, false
+	}
+	comment, ok := app.comment(w, r)
+	if !ok {
+		return nil, false
+	}
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return nil, false
+	}
+	if !comment.Live() {
+		app.NotFound(w)
+		return nil, false
+	}
+	if !comment.OwnedBy(user) {
+		app.ClientError(w, http.StatusForbidden)
+		return nil, false
+	}
+	return comment, true
+}
@@ -1,15 +1,17 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"runtime/debug"
 )
 
 // The ServerError helper writes an error message and stack trace to the log, then
-// sends a generic 500 Internal Server Error response to the user.
-func (app *App) ServerError(w http.ResponseWriter, err error) {
-	log.Printf("%s\n%s", err.Error(), debug.Stack())
+// sends a generic 500 Internal Server Error response to the user. The request
+// is only used to tag the log entry with its request ID.
+func (app *App) ServerError(w http.ResponseWriter, r *http.Request, err error) {
+	app.requestLogger(r).Error(err.Error(), slog.String("stack", string(debug.Stack())))
 	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 }
 
@@ -27,3 +29,7 @@ func (app *App) NotFound(w http.ResponseWriter) {
 	app.ClientError(w, http.StatusNotFound)
 
 }
+
+// errSelfModeration is returned when an admin tries to disable or demote their
+// own account.
+var errSelfModeration = errors.New("main: cannot moderate own account")
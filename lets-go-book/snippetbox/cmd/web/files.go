@@ -0,0 +1,73 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
+)
+
+// SnippetZip downloads a snippet as a zip archive of its files, with its
+// content as a README alongside them.
+func (app *App) SnippetZip(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.snippet(w, r)
+	if !ok {
+		return
+	}
+	// Only the browser can decrypt an end-to-end encrypted snippet.
+	if snippet.E2E {
+		app.NotFound(w)
+		return
+	}
+
+	size := int64(len(snippet.Content))
+	for _, file := range snippet.Files {
+		size += int64(len(file.Content))
+	}
+	extendDeadlines(w, time.Time{}, time.Now().Add(transferTime(size)))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="snippet-%d.zip"`, snippet.ID))
+	// From here on the archive is streamed, so a failure can only be logged;
+	// the client is left with a truncated download.
+	zw := zip.NewWriter(w)
+	for _, file := range zipFiles(snippet) {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     file.Name,
+			Method:   zip.Deflate,
+			Modified: snippet.Created,
+		})
+		if err == nil {
+			_, err = fw.Write([]byte(file.Content))
+		}
+		if err != nil {
+			app.requestLogger(r).Error("writing zip", slog.String("error", err.Error()))
+			return
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		app.requestLogger(r).Error("writing zip", slog.String("error", err.Error()))
+	}
+}
+
+// zipFiles returns what goes in a snippet's archive: a README holding its
+// content (README.md for Markdown), then its files. If one of the files is
+// already called that, the README makes way.
+func zipFiles(snippet *models.Snippet) []models.File {
+	readme := "README.txt"
+	if snippet.Format == models.FormatMarkdown {
+		readme = "README.md"
+	}
+	for _, file := range snippet.Files {
+		if file.Name == readme {
+			readme = fmt.Sprintf("snippet-%d-%s", snippet.ID, readme)
+			break
+		}
+	}
+	files := []models.File{{Name: readme, Content: snippet.Content}}
+	return append(files, snippet.Files...)
+}
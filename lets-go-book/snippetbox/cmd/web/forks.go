@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/diff"
+	"github.com/noelruault/lets-go/snippetbox/pkg/forms"
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
+)
+
+// ForkSnippet shows the new snippet form filled in with a copy of the snippet
+// in the URL. Saving it (see CreateSnippet) makes a new snippet, owned by the
+// current user, that remembers which one it was forked from.
+func (app *App) ForkSnippet(w http.ResponseWriter, r *http.Request) {
+	parent, ok := app.snippet(w, r)
+	if !ok {
+		return
+	}
+	// We couldn't copy what we can't read.
+	if parent.E2E {
+		app.NotFound(w)
+		return
+	}
+	form := forms.New(url.Values{
+		"title":     {parent.Title},
+		"content":   {parent.Content},
+		"format":    {string(parent.Format)},
+		"tags":      {strings.Join(parent.Tags, ", ")},
+		"parent_id": {strconv.Itoa(parent.ID)},
+	}, printer(r))
+	app.RenderHTML(w, r, "newpage.html", &HTMLData{Files: parent.Files, Form: form})
+}
+
+// SnippetDiff shows how the fork in the URL differs from its parent.
+func (app *App) SnippetDiff(w http.ResponseWriter, r *http.Request) {
+	fork, ok := app.snippet(w, r)
+	if !ok {
+		return
+	}
+	if fork.ParentID == 0 || fork.E2E {
+		app.NotFound(w)
+		return
+	}
+	parent, err := app.Database.GetSnippet(r.Context(), fork.ParentID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	// There's nothing to compare against once the parent has expired or been
+	// removed.
+	if parent == nil || parent.E2E {
+		app.NotFound(w)
+		return
+	}
+	app.RenderHTML(w, r, "diffpage.html", &HTMLData{
+		Diff:    diff.Compare(parent.Content, fork.Content),
+		Parent:  parent,
+		Snippet: fork,
+	})
+}
+
+// snippet loads the live snippet with the :id in the URL. If it returns false
+// it has already sent the response.
+func (app *App) snippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.NotFound(w)
+		return nil, false
+	}
+	snippet, err := app.Database.GetSnippet(r.Context(), id)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return nil, false
+	}
+	if snippet == nil {
+		app.NotFound(w)
+		return nil, false
+	}
+	return snippet, true
+}
@@ -4,22 +4,62 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/noelruault/lets-go/snippetbox/pkg/forms"
+	"github.com/noelruault/lets-go/snippetbox/pkg/markdown"
 	"github.com/noelruault/lets-go/snippetbox/pkg/models"
 )
 
+// popularWindow is how far back the homepage's popular sort counts stars.
+const popularWindow = 7 * 24 * time.Hour
+
 func (app *App) Home(w http.ResponseWriter, r *http.Request) {
-	// Fetch a slice of the latest snippets from the database.
-	snippets, err := app.Database.LatestSnippets()
+	// Fetch a slice of the latest snippets from the database, or the most
+	// starred ones with ?sort=popular.
+	sort := r.URL.Query().Get("sort")
+	var snippets models.Snippets
+	var err error
+	if sort == "popular" {
+		snippets, err = app.Database.PopularSnippets(r.Context(), popularWindow)
+	} else {
+		sort = ""
+		snippets, err = app.Database.LatestSnippets(r.Context())
+	}
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
+		return
+	}
+	// And the most used tags, for the tag cloud.
+	tags, err := app.Database.TagCloud(r.Context(), 30)
+	if err != nil {
+		app.ServerError(w, r, err)
 		return
 	}
 	// Pass the slice of snippets to the "homepage.html" templates.
 	// Include the *http.Request parameter.
 	app.RenderHTML(w, r, "homepage.html", &HTMLData{
 		Snippets: snippets,
+		Sort:     sort,
+		Tags:     tags,
+	})
+}
+
+// ShowTag lists the latest snippets with the tag in the URL.
+func (app *App) ShowTag(w http.ResponseWriter, r *http.Request) {
+	tag := models.NormalizeTag(r.URL.Query().Get(":name"))
+	if !models.ValidTag(tag) {
+		app.NotFound(w)
+		return
+	}
+	snippets, err := app.Database.TaggedSnippets(r.Context(), tag)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.RenderHTML(w, r, "tagpage.html", &HTMLData{
+		Snippets: snippets,
+		Tag:      tag,
 	})
 }
 
@@ -31,9 +71,9 @@ func (app *App) ShowSnippet(w http.ResponseWriter, r *http.Request) {
 		app.NotFound(w)
 		return
 	}
-	snippet, err := app.Database.GetSnippet(id)
+	snippet, err := app.Database.GetSnippet(r.Context(), id)
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 	if snippet == nil {
@@ -44,64 +84,157 @@ func (app *App) ShowSnippet(w http.ResponseWriter, r *http.Request) {
 	session := app.Sessions.Load(r)
 	flash, err := session.PopString(w, "flash") // PopString will delete flash after reading
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 
 	// Render the showpage.html template, passing in the snippet data
-	// wrapped in our HTMLData struct.
+	// wrapped in our HTMLData struct, with an empty comment form.
 	// Include the *http.Request parameter. (r)
-	app.RenderHTML(w, r, "showpage.html", &HTMLData{
-		Flash:   flash, // Pass the flash message to the template.
-		Snippet: snippet,
+	app.renderSnippet(w, r, snippet, &HTMLData{
+		Flash: flash, // Pass the flash message to the template.
+		Form:  forms.New(nil, printer(r)),
 	})
 }
 
+// renderSnippet renders showpage.html for the snippet with its comments and,
+// for a logged in user, their collections to add it to and whether they have
+// starred it. Markdown snippets are rendered (and sanitized) here.
+func (app *App) renderSnippet(w http.ResponseWriter, r *http.Request, snippet *models.Snippet, data *HTMLData) {
+	comments, err := app.Database.SnippetComments(r.Context(), snippet.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	if user != nil {
+		data.Collections, err = app.Database.UserCollections(r.Context(), user.ID)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+		data.Starred, err = app.Database.HasStarred(r.Context(), user.ID, snippet.ID)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+	}
+	data.Comments = comments
+	data.Snippet = snippet
+	if snippet.Format == models.FormatMarkdown && !snippet.E2E {
+		data.SnippetHTML, err = markdown.Render(snippet.Content)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+	}
+	app.RenderHTML(w, r, "showpage.html", data)
+}
+
 func (app *App) NewSnippet(w http.ResponseWriter, r *http.Request) {
-	// Pass an empty *forms.NewSnippet object to the newpage.html template. Because
+	// Pass an empty *forms.Form object to the newpage.html template. Because
 	// it's empty, it won't contain any previously submitted data or validation
 	// failure messages.
 	app.RenderHTML(w, r, "newpage.html", &HTMLData{
-		Form: &forms.NewSnippet{},
+		Form: forms.New(nil, printer(r)),
 	})
 }
 
 func (app *App) CreateSnippet(w http.ResponseWriter, r *http.Request) {
-	// First we call r.ParseForm() which adds any POST (also PUT and PATCH) data
-	// to the r.PostForm map. If there are any errors we use our
-	// app.ClientError helper to send a 400 Bad Request response to the user.
+	// The form is multipart, to carry attachments, and the ParseUpload
+	// middleware has already parsed it, which also adds the other POST (also
+	// PUT and PATCH) data to the r.PostForm map as r.ParseForm() would. Here
+	// r.ParseForm() just picks up a form that wasn't multipart. If there are
+	// any errors we use our app.ClientError helper to send a 400 Bad Request
+	// response to the user.
 	err := r.ParseForm()
 	if err != nil {
 		app.ClientError(w, http.StatusBadRequest)
 		return
 	}
-	// We initialize a *forms.NewSnippet object and use the r.PostForm.Get() method
-	// to assign the data to the relevant fields.
-	form := &forms.NewSnippet{
-		Title:   r.PostForm.Get("title"),
-		Content: r.PostForm.Get("content"),
-		Expires: r.PostForm.Get("expires"),
+	// We wrap the r.PostForm data in a *forms.Form, which knows how to check
+	// it (translating any failure messages), and decode it into a
+	// forms.NewSnippet.
+	form := forms.New(r.PostForm, printer(r))
+	snippet := forms.NewSnippet{Policy: app.UploadPolicy}
+	err = form.Decode(&snippet)
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	if r.MultipartForm != nil {
+		snippet.Uploads = r.MultipartForm.File["attachments"]
+	}
+	// "Add a file" submits the form too, to show it again with another empty
+	// file to fill in; nothing is saved or checked yet.
+	if r.PostForm.Get("add_file") != "" {
+		app.RenderHTML(w, r, "newpage.html", &HTMLData{Files: snippet.Files(), Form: form})
+		return
+	}
+	// A fork can only be saved while the snippet it was forked from is still
+	// around.
+	if snippet.Parent != 0 {
+		parent, err := app.Database.GetSnippet(r.Context(), snippet.Parent)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+		form.Check(parent != nil, "parent_id",
+			printer(r).T("The snippet you are forking has expired or been removed"))
+	}
+	err = snippet.CheckUploads(form)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	// Check if the form passes the validation checks. If not, re-display the
+	// form with the failure messages.
+	if !snippet.Valid(form) {
+		app.RenderHTML(w, r, "newpage.html", &HTMLData{Files: snippet.Files(), Form: form})
+		return
 	}
-	// Check if the form passes the validation checks. If not, then use the
-	// fmt.Fprint function to dump the failure messages to the response body.
-	if !form.Valid() {
-		// fmt.Fprint(w, form.Failures) // Will break the page. Rendering raw html.
-		app.RenderHTML(w, r, "newpage.html", &HTMLData{Form: form})
+	// RequireLogin has already checked there is a current user, who will own
+	// the snippet.
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	// The attachments go in the blob store first, so the snippet is only
+	// saved once they are safely there.
+	attachments, err := app.saveAttachments(r, snippet.Uploads)
+	if err != nil {
+		app.ServerError(w, r, err)
 		return
 	}
 	// If the validation checks have been passed, call our database model's
 	// InsertSnippet() method to create a new database record and return it's ID
 	// value.
-	id, err := app.Database.InsertSnippet(form.Title, form.Content, form.Expires)
+	id, err := app.Database.InsertSnippet(r.Context(), &models.Snippet{
+		UserID:      user.ID,
+		ParentID:    snippet.Parent,
+		Title:       snippet.Title,
+		Content:     snippet.Content,
+		Format:      models.Format(snippet.Format),
+		E2E:         snippet.E2E,
+		Tags:        snippet.TagList(),
+		Files:       snippet.Files(),
+		Attachments: attachments,
+	}, snippet.Expires)
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
+	app.Metrics.SnippetsCreated.Inc()
 	session := app.Sessions.Load(r)
-	err = session.PutString(w, "flash", "Your snippet was saved successfully!")
+	err = session.PutString(w, "flash", printer(r).T("Your snippet was saved successfully!"))
 	// ...other methods than PutString: https://godoc.org/github.com/alexedwards/scs#pkg-index
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 
@@ -112,7 +245,7 @@ func (app *App) CreateSnippet(w http.ResponseWriter, r *http.Request) {
 
 func (app *App) SignupUser(w http.ResponseWriter, r *http.Request) {
 	app.RenderHTML(w, r, "signuppage.html", &HTMLData{
-		Form: &forms.SignupUser{}})
+		Form: forms.New(nil, printer(r))})
 }
 
 func (app *App) CreateUser(w http.ResponseWriter, r *http.Request) {
@@ -121,35 +254,37 @@ func (app *App) CreateUser(w http.ResponseWriter, r *http.Request) {
 		app.ClientError(w, http.StatusBadRequest)
 		return
 	}
-	form := &forms.SignupUser{
-		Name:     r.PostForm.Get("name"),
-		Email:    r.PostForm.Get("email"),
-		Password: r.PostForm.Get("password"),
+	form := forms.New(r.PostForm, printer(r))
+	user := forms.SignupUser{Policy: app.PasswordPolicy}
+	err = form.Decode(&user)
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
 	}
-	if !form.Valid() {
+	if !user.Valid(form) {
 		app.RenderHTML(w, r, "signuppage.html", &HTMLData{Form: form})
 		return
 	}
 
 	// Try to create a new user record in the database. If the email already exists
 	// add a failure message to the form and re-display the form.
-	err = app.Database.InsertUser(form.Name, form.Email, form.Password)
+	err = app.Database.InsertUser(r.Context(), user.Name, user.Email, user.Password)
 	if err == models.ErrDuplicateEmail {
-		form.Failures["Email"] = "Address is already in use"
+		form.Errors.Add("email", printer(r).T("Address is already in use"))
 		app.RenderHTML(w, r, "signuppage.html", &HTMLData{Form: form})
 		return
 	} else if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 
 	// Otherwise, add a confirmation flash message to the session confirming that
 	// their signup worked and asking them to log in.
-	msg := "Your signup was successful. Please log in using your credentials."
+	msg := printer(r).T("Your signup was successful. Please log in using your credentials.")
 	session := app.Sessions.Load(r)
 	err = session.PutString(w, "flash", msg)
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 	// And redirect the user to the login page.
@@ -160,12 +295,12 @@ func (app *App) LoginUser(w http.ResponseWriter, r *http.Request) {
 	session := app.Sessions.Load(r)
 	flash, err := session.PopString(w, "flash")
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 	app.RenderHTML(w, r, "loginpage.html", &HTMLData{
 		Flash: flash,
-		Form:  &forms.LoginUser{},
+		Form:  forms.New(nil, printer(r)),
 	})
 }
 
@@ -175,23 +310,31 @@ func (app *App) VerifyUser(w http.ResponseWriter, r *http.Request) {
 		app.ClientError(w, http.StatusBadRequest)
 		return
 	}
-	form := &forms.LoginUser{
-		Email:    r.PostForm.Get("email"),
-		Password: r.PostForm.Get("password")}
-
-	if !form.Valid() {
+	form := forms.New(r.PostForm, printer(r))
+	var login forms.LoginUser
+	err = form.Decode(&login)
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	if !login.Valid(form) {
 		app.RenderHTML(w, r, "loginpage.html", &HTMLData{Form: form})
 		return
 	}
 	// Check whether the credentials are valid. If they're not, add a generic error
 	// message to the form failures map, and re-display the login page.
-	currentUserID, err := app.Database.VerifyUser(form.Email, form.Password)
+	currentUserID, err := app.Database.VerifyUser(r.Context(), login.Email, login.Password)
 	if err == models.ErrInvalidCredentials {
-		form.Failures["Generic"] = "Email or Password is incorrect"
+		app.Metrics.FailedLogins.Inc()
+		form.Errors.Add("generic", printer(r).T("Email or Password is incorrect"))
+		app.RenderHTML(w, r, "loginpage.html", &HTMLData{Form: form})
+		return
+	} else if err == models.ErrAccountDisabled {
+		form.Errors.Add("generic", printer(r).T("Your account has been disabled"))
 		app.RenderHTML(w, r, "loginpage.html", &HTMLData{Form: form})
 		return
 	} else if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 	// Add the ID of the current user to the session, so that they are now 'logged
@@ -199,7 +342,12 @@ func (app *App) VerifyUser(w http.ResponseWriter, r *http.Request) {
 	session := app.Sessions.Load(r)
 	err = session.PutInt(w, "currentUserID", currentUserID)
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
+		return
+	}
+	err = app.restorePreferences(w, r, currentUserID)
+	if err != nil {
+		app.ServerError(w, r, err)
 		return
 	}
 	// Redirect the user to the Add Snippet page.
@@ -211,7 +359,7 @@ func (app *App) LogoutUser(w http.ResponseWriter, r *http.Request) {
 	session := app.Sessions.Load(r)
 	err := session.Remove(w, "currentUserID")
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
 	// Redirect the user to the homepage.
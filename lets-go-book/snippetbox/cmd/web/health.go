@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
+)
+
+// checkTimeout bounds each readiness check, so that a hung database makes
+// /readyz fail rather than hang.
+const checkTimeout = 2 * time.Second
+
+type checkResult struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// Healthz is the liveness probe. If the process can answer at all it's alive,
+// so it doesn't look at any dependencies: restarting us wouldn't fix them.
+func (app *App) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, &healthResponse{Status: "ok"})
+}
+
+// Readyz is the readiness probe. It reports whether we can usefully serve
+// traffic right now, with the outcome of each check in the JSON body, and
+// starts failing as soon as a graceful shutdown begins.
+func (app *App) Readyz(w http.ResponseWriter, r *http.Request) {
+	resp := &healthResponse{Status: "ok", Checks: map[string]checkResult{}}
+	checks := map[string]func(context.Context) (string, error){
+		"shutdown":  app.checkShutdown,
+		"database":  app.checkDatabase,
+		"migration": app.checkMigration,
+		"sessions":  app.checkSessions,
+		"templates": app.checkTemplates,
+	}
+	for name, check := range checks {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		detail, err := check(ctx)
+		cancel()
+		if err != nil {
+			resp.Status = "fail"
+			resp.Checks[name] = checkResult{Status: "fail", Detail: err.Error()}
+			continue
+		}
+		resp.Checks[name] = checkResult{Status: "ok", Detail: detail}
+	}
+
+	status := http.StatusOK
+	if resp.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, resp)
+}
+
+func (app *App) checkShutdown(ctx context.Context) (string, error) {
+	if app.shuttingDown.Load() {
+		return "", fmt.Errorf("shutting down")
+	}
+	return "", nil
+}
+
+func (app *App) checkDatabase(ctx context.Context) (string, error) {
+	return "", app.Database.PingContext(ctx)
+}
+
+func (app *App) checkMigration(ctx context.Context) (string, error) {
+	version, err := app.Database.MigrationVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	if version < models.SchemaVersion {
+		return "", fmt.Errorf("schema is at version %d, need %d", version, models.SchemaVersion)
+	}
+	return fmt.Sprintf("version %d", version), nil
+}
+
+// checkSessions makes sure the session manager is set up. Sessions live in
+// signed, encrypted cookies, so there's no external store to reach.
+func (app *App) checkSessions(ctx context.Context) (string, error) {
+	if app.Sessions == nil {
+		return "", fmt.Errorf("no session manager")
+	}
+	return "cookie store", nil
+}
+
+// checkTemplates makes sure the template cache is populated. Pages are
+// parsed at startup, which fails outright if any of them is broken, so this
+// mostly catches a dev mode reload that went wrong.
+func (app *App) checkTemplates(ctx context.Context) (string, error) {
+	if _, err := app.Templates.Get("homepage.html"); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d pages", app.Templates.Len()), nil
+}
+
+func writeHealth(w http.ResponseWriter, status int, resp *healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"log/slog"
 	"net/http"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
 )
 
 func (app *App) LoggedIn(r *http.Request) (bool, error) {
@@ -15,3 +18,27 @@ func (app *App) LoggedIn(r *http.Request) (bool, error) {
 	}
 	return loggedIn, nil
 }
+
+// CurrentUser returns the user who is logged in for the current request, or
+// nil if nobody is. A disabled account counts as nobody.
+func (app *App) CurrentUser(r *http.Request) (*models.User, error) {
+	session := app.Sessions.Load(r)
+	id, err := session.GetInt("currentUserID")
+	if err != nil || id == 0 {
+		return nil, err
+	}
+	user, err := app.Database.GetUser(r.Context(), id)
+	if err != nil || user == nil || user.Disabled {
+		return nil, err
+	}
+	return user, nil
+}
+
+// requestLogger returns the application logger with the current request's ID
+// attached, so every line logged while handling it can be tied together.
+func (app *App) requestLogger(r *http.Request) *slog.Logger {
+	if id, ok := r.Context().Value(contextKeyRequestID).(string); ok {
+		return app.Logger.With(slog.String("request_id", id))
+	}
+	return app.Logger
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/i18n"
+)
+
+const (
+	contextKeyPrinter  = contextKey("printer")
+	contextKeyLocation = contextKey("location")
+)
+
+// Localize picks the language for the request: the one chosen with the
+// language switcher (or saved with the user's account, see
+// restorePreferences) if there is one, or else the best match for the
+// browser's Accept-Language. Handlers get at it with printer(r).
+//
+// It does the same for the time zone dates are shown in, which is the one
+// saved in the user's settings or else the one reported by the browser (see
+// the script in base.html), falling back to UTC. That's location(r).
+func (app *App) Localize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := app.Sessions.Load(r)
+		ctx := r.Context()
+		if app.Catalog != nil {
+			locale, _ := session.GetString("locale")
+			p := app.Catalog.Printer(locale, r.Header.Get("Accept-Language"))
+			w.Header().Set("Content-Language", p.Lang())
+			addVary(w.Header(), "Accept-Language")
+			ctx = context.WithValue(ctx, contextKeyPrinter, p)
+		}
+
+		zone, _ := session.GetString("timeZone")
+		if zone == "" {
+			zone = browserTimeZone(r)
+		}
+		if loc, ok := loadLocation(zone); ok {
+			ctx = context.WithValue(ctx, contextKeyLocation, loc)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// location returns the time zone to show the request's dates in.
+func location(r *http.Request) *time.Location {
+	if loc, ok := r.Context().Value(contextKeyLocation).(*time.Location); ok {
+		return loc
+	}
+	return time.UTC
+}
+
+// browserTimeZone returns the time zone the browser reported in its tz
+// cookie, or "" if it hasn't (yet).
+func browserTimeZone(r *http.Request) string {
+	c, err := r.Cookie("tz")
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+// locations caches loaded time zones, which would otherwise be read from the
+// zoneinfo database on every request.
+var locations sync.Map
+
+// loadLocation loads the named IANA time zone, reporting false if it's empty
+// or not a zone we know. Like forms.Settings, it refuses "Local", which
+// LoadLocation takes to mean the server's zone.
+func loadLocation(name string) (*time.Location, bool) {
+	if name == "" || name == "Local" || len(name) > 64 {
+		return nil, false
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), true
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, false
+	}
+	locations.Store(name, loc)
+	return loc, true
+}
+
+// printer returns the request's Printer. Outside of Localize it's nil, which
+// prints English.
+func printer(r *http.Request) *i18n.Printer {
+	p, _ := r.Context().Value(contextKeyPrinter).(*i18n.Printer)
+	return p
+}
+
+// SetLocale handles the language switcher in the page footer. The choice is
+// kept in the session, and saved with the account of a logged in user so it
+// follows them to other browsers.
+func (app *App) SetLocale(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	locale := r.PostForm.Get("locale")
+	if !app.Catalog.Supported(locale) {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	session := app.Sessions.Load(r)
+	err = session.PutString(w, "locale", locale)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	if user != nil {
+		err = app.Database.SetUserLocale(r.Context(), user.ID, locale)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+	}
+
+	// Go back to the page the switcher was on, as long as it's one of ours.
+	http.Redirect(w, r, localPath(r.PostForm.Get("next")), http.StatusSeeOther)
+}
+
+// localPath returns next if it's a path on this site, and "/" otherwise.
+// Browsers read "//host", "/\host", and either of those with tabs or
+// newlines in the middle (which they strip) as another site, so anything
+// with a control character or backslash is refused outright.
+func localPath(next string) string {
+	if strings.IndexFunc(next, func(r rune) bool { return r < 0x20 || r == 0x7f || r == '\\' }) >= 0 {
+		return "/"
+	}
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		return "/"
+	}
+	return next
+}
+
+// restorePreferences is called when a user logs in, to switch to the
+// language and time zone saved with their account (if any).
+func (app *App) restorePreferences(w http.ResponseWriter, r *http.Request, userID int) error {
+	user, err := app.Database.GetUser(r.Context(), userID)
+	if err != nil || user == nil {
+		return err
+	}
+	session := app.Sessions.Load(r)
+	if user.Locale != "" {
+		err = session.PutString(w, "locale", user.Locale)
+		if err != nil {
+			return err
+		}
+	}
+	return session.PutString(w, "timeZone", user.TimeZone)
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		next, want string
+	}{
+		{"/", "/"},
+		{"/snippet/1", "/snippet/1"},
+		{"/tag/go?page=2#top", "/tag/go?page=2#top"},
+		{"", "/"},
+		{"snippet/1", "/"},
+		{"https://evil.example/", "/"},
+		{"//evil.example", "/"},
+		{"/\\evil.example", "/"},
+		{"/\t/evil.example", "/"},
+		{"/\n/evil.example", "/"},
+		{"/\r\n/evil.example", "/"},
+		{"\t//evil.example", "/"},
+		{"javascript:alert(1)", "/"},
+	}
+	for _, tt := range tests {
+		if got := localPath(tt.next); got != tt.want {
+			t.Errorf("localPath(%q) = %q; want %q", tt.next, got, tt.want)
+		}
+	}
+}
@@ -1,35 +1,114 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
 	"log"
+	"log/slog"
+	"os"
+	"strings"
 	"time"
+	_ "time/tzdata" // Users pick time zones, which shouldn't depend on the host having zoneinfo
 
 	"github.com/alexedwards/scs"
 	_ "github.com/go-sql-driver/mysql" // main.go doesn't actually use anything in the mysql package
+	"github.com/noelruault/lets-go/snippetbox/pkg/blob"
+	"github.com/noelruault/lets-go/snippetbox/pkg/bloom"
+	"github.com/noelruault/lets-go/snippetbox/pkg/forms"
+	"github.com/noelruault/lets-go/snippetbox/pkg/i18n"
 	"github.com/noelruault/lets-go/snippetbox/pkg/models"
+	"github.com/noelruault/lets-go/snippetbox/pkg/sso"
+	"github.com/noelruault/lets-go/snippetbox/ui"
 )
 
 func main() {
 	addr := flag.String("addr", ":4000", "HTTP network address")
+	attachmentMaxMB := flag.Int64("attachment-max-mb", 10, "Largest attachment allowed, in megabytes")
+	attachmentTypes := flag.String("attachment-types", strings.Join(forms.DefaultUploadPolicy.Types, ","), "Comma separated media types attachments can be")
+	bcryptCost := flag.Int("bcrypt-cost", 12, "bcrypt cost for new password hashes")
+	blobDir := flag.String("blob-dir", "./blobs", "Directory for the local blob store")
+	blobStore := flag.String("blob-store", "local", "Where to keep attachments (local or s3)")
+	breached := flag.String("breached-passwords", "", "Path to a list of breached passwords, one per line")
+	cspPolicy := flag.String("csp", DefaultCSP, "Content-Security-Policy ({nonce} is replaced per request; empty to disable)")
+	cspReportOnly := flag.Bool("csp-report-only", false, "Only report Content-Security-Policy violations, don't block them")
+	dbConnMaxIdleTime := flag.Duration("db-conn-max-idle-time", 5*time.Minute, "Close database connections idle for longer than this")
+	dbConnMaxLifetime := flag.Duration("db-conn-max-lifetime", time.Hour, "Close database connections older than this")
+	dbMaxIdle := flag.Int("db-max-idle", 5, "Maximum idle database connections")
+	dbMaxOpen := flag.Int("db-max-open", 25, "Maximum open database connections (0 for no limit)")
+	dbQueryTimeout := flag.Duration("db-query-timeout", 3*time.Second, "Time limit for each database operation (0 for none)")
 	dsn := flag.String("dsn", "sb:pass@/snippetbox?parseTime=true", "MySQL DSN")
-	htmlDir := flag.String("html-dir", "./ui/html", "Path to HTML templates")
+	dev := flag.Bool("dev", false, "Re-parse templates when they change (reads them from -html-dir, default ./ui/html)")
+	encryptionKeys := flag.String("encryption-keys", "", "File of keys to encrypt snippets and attachments at rest, one \"ID base64-key\" per line; the last is current")
+	htmlDir := flag.String("html-dir", "", "Path to HTML templates (default: the ones built into the binary)")
+	logFormat := flag.String("log-format", "logfmt", "Log format (logfmt or json)")
+	logLevel := flag.String("log-level", "info", "Minimum log level (debug, info, warn or error)")
+	metricsAddr := flag.String("metrics-addr", "", "Serve /metrics on this separate (plain HTTP) address")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP collector host:port (default from OTEL_EXPORTER_OTLP_ENDPOINT)")
+	otlpInsecure := flag.Bool("otlp-insecure", false, "Send traces to the collector over plain HTTP")
+	oidcAllowedDomain := flag.String("oidc-allowed-domain", "", "Only allow single sign-on for emails at this domain")
+	oidcClientID := flag.String("oidc-client-id", "", "OpenID Connect client ID")
+	oidcClientSecret := flag.String("oidc-client-secret", "", "OpenID Connect client secret")
+	oidcIssuer := flag.String("oidc-issuer", "", "OpenID Connect issuer URL (enables single sign-on)")
+	oidcRedirectURL := flag.String("oidc-redirect-url", "https://localhost:4000/user/login/sso/callback", "OpenID Connect redirect URL")
+	passwordAlgorithm := flag.String("password-algorithm", models.Bcrypt, "Hash for new passwords (bcrypt or argon2id)")
+	passwordMax := flag.Int("password-max", 72, "Maximum password length")
+	passwordMin := flag.Int("password-min", 8, "Minimum password length")
+	s3AccessKey := flag.String("s3-access-key", os.Getenv("AWS_ACCESS_KEY_ID"), "S3 access key ID")
+	s3Bucket := flag.String("s3-bucket", "", "S3 bucket for attachments")
+	s3Endpoint := flag.String("s3-endpoint", "https://s3.amazonaws.com", "S3 (or S3-compatible) service URL")
+	s3Prefix := flag.String("s3-prefix", "attachments/", "Prefix for attachment keys in the S3 bucket")
+	s3Region := flag.String("s3-region", "us-east-1", "S3 region")
+	s3SecretKey := flag.String("s3-secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "S3 secret access key")
 	secret := flag.String("secret", "s6Nd%+pPbnzHbS*+9Pk8qGWhTzbpa@ge", "Secret key")
-	staticDir := flag.String("static-dir", "./ui/static", "Path to static assets")
+	shutdownDelay := flag.Duration("shutdown-delay", 5*time.Second, "How long to fail readiness before shutting down")
+	staticDir := flag.String("static-dir", "", "Path to static assets (default: the ones built into the binary)")
+	traceExporter := flag.String("trace-exporter", "none", "Where to send traces (none, stdout or otlp)")
 	tlsCert := flag.String("tls-cert", "./tls/cert.pem", "Path to TLS certificate")
 	tlsKey := flag.String("tls-key", "./tls/key.pem", "Path to TLS key")
 
 	flag.Parse()
 
+	// Set up the structured logger first, and make it the default so that
+	// anything still using the standard log package goes through it as well.
+	logger, err := newLogger(os.Stdout, *logFormat, *logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
+	// Tracing is off unless an exporter is chosen.
+	exporter, err := NewSpanExporter(context.Background(), *traceExporter, *otlpEndpoint, *otlpInsecure)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if exporter != nil {
+		tp, err := SetupTracing(exporter)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Flush any spans still buffered once the server has stopped.
+		defer tp.Shutdown(context.Background())
+	}
+
 	// To keep the main() function tidy I've put the code for creating a connection
 	// pool into the separate connect() function below. We pass connect() the DSN
 	// from the command-line flag.
-	db := connect(*dsn)
+	db := connect(*dsn, *dbQueryTimeout)
+	// Bound the pool, so a traffic spike queues up here instead of opening
+	// more connections than MySQL allows, and recycle connections regularly.
+	db.SetMaxOpenConns(*dbMaxOpen)
+	db.SetMaxIdleConns(*dbMaxIdle)
+	db.SetConnMaxLifetime(*dbConnMaxLifetime)
+	db.SetConnMaxIdleTime(*dbConnMaxIdleTime)
 	// We also defer a call to db.Close(), so that the connection pool is closed
 	// before the main() function exits.
-	// ... Our application is only ever terminated by a signal interrupt
-	//(i.e. Ctrl+c) or by log.Fatal
+	// ... RunServer returns once the server has shut down gracefully after a
+	// SIGINT (i.e. Ctrl+c) or SIGTERM; anything else ends in log.Fatal.
 	defer db.Close()
 
 	// Use the scs.NewCookieManager() function to initialize a new session manager,
@@ -42,15 +121,143 @@ func main() {
 	sessionManager.Secure(true) // Set the Secure flag on our session cookies
 	// ... other methods: https://godoc.org/github.com/alexedwards/scs#pkg-index
 
+	// Existing hashes made with another algorithm or a lower cost keep working,
+	// and are upgraded the next time their owner logs in.
+	hasher := &models.PasswordHasher{Algorithm: *passwordAlgorithm, BcryptCost: *bcryptCost}
+	if _, err := hasher.Hash(""); err != nil {
+		log.Fatal(err)
+	}
+	policy := &forms.PasswordPolicy{MinLength: *passwordMin, MaxLength: *passwordMax}
+	if *passwordAlgorithm == models.Bcrypt {
+		if *passwordMax > models.BcryptMaxBytes {
+			log.Fatalf("-password-max can't be more than %d with bcrypt", models.BcryptMaxBytes)
+		}
+		policy.MaxBytes = models.BcryptMaxBytes
+	}
+	if *breached != "" {
+		policy.Breached, err = bloom.LoadFile(*breached, 0.001)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Attachments are kept in a local directory by default, or in an S3
+	// bucket.
+	var blobs blob.Store
+	switch *blobStore {
+	case "local":
+		blobs, err = blob.NewLocal(*blobDir)
+	case "s3":
+		blobs, err = blob.NewS3(blob.S3Config{
+			Endpoint:  *s3Endpoint,
+			Bucket:    *s3Bucket,
+			Region:    *s3Region,
+			AccessKey: *s3AccessKey,
+			SecretKey: *s3SecretKey,
+			Prefix:    *s3Prefix,
+		})
+	default:
+		err = fmt.Errorf("unknown blob store %q", *blobStore)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	uploads := &forms.UploadPolicy{MaxSize: *attachmentMaxMB << 20, Types: strings.Split(*attachmentTypes, ",")}
+
+	// Without keys, new snippets and attachments are stored as they are.
+	var keys *models.Keyring
+	if *encryptionKeys != "" {
+		keys, err = models.LoadKeyring(*encryptionKeys)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Pass in the connection pool when initializing the models.Database object.
+	database := &models.Database{DB: db, Keys: keys, Passwords: hasher, QueryTimeout: *dbQueryTimeout}
+
+	// "snippetbox rotate-keys" re-wraps the data keys with the current key
+	// (and encrypts anything stored before there were keys), then exits
+	// rather than starting the server.
+	switch flag.Arg(0) {
+	case "":
+	case "rotate-keys":
+		err := rotateKeys(database, blobs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	default:
+		log.Fatalf("unknown command %q", flag.Arg(0))
+	}
+
+	// Single sign-on is optional. The provider's discovery document is fetched
+	// once, here, so a misconfigured issuer stops the server from starting.
+	var ssoProvider *sso.Provider
+	if *oidcIssuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ssoProvider, err = sso.New(ctx, sso.Config{
+			IssuerURL:     *oidcIssuer,
+			ClientID:      *oidcClientID,
+			ClientSecret:  *oidcClientSecret,
+			RedirectURL:   *oidcRedirectURL,
+			AllowedDomain: *oidcAllowedDomain,
+		})
+		cancel()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The UI is built into the binary, but either half can be read from disk
+	// instead; dev mode needs the templates on disk to notice edits.
+	if *dev && *htmlDir == "" {
+		*htmlDir = "./ui/html"
+	}
+	htmlFS, err := uiFS(*htmlDir, "html")
+	if err != nil {
+		log.Fatal(err)
+	}
+	staticFS, err := uiFS(*staticDir, "static")
+	if err != nil {
+		log.Fatal(err)
+	}
+	static, err := NewAssets(staticFS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	catalog, err := i18n.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Parse every template now, so a broken one stops us from starting rather
+	// than breaking a page at runtime.
+	templates, err := newTemplateCache(htmlFS, *dev, template.FuncMap{
+		"asset":     static.URL,
+		"languages": catalog.Languages,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	app := &App{
-		Addr: *addr,
-		// Pass in the connection pool when initializing the models.Database object.
-		Database:  &models.Database{db},
-		HTMLDir:   *htmlDir,
-		Sessions:  sessionManager,
-		StaticDir: *staticDir,
-		TLSCert:   *tlsCert,
-		TLSKey:    *tlsKey,
+		Addr:           *addr,
+		Blobs:          database.SealBlobs(blobs),
+		Catalog:        catalog,
+		CSPPolicy:      *cspPolicy,
+		CSPReportOnly:  *cspReportOnly,
+		Database:       database,
+		Logger:         logger,
+		Metrics:        NewMetrics(db),
+		MetricsAddr:    *metricsAddr,
+		PasswordPolicy: policy,
+		Sessions:       sessionManager,
+		ShutdownDelay:  *shutdownDelay,
+		SSO:            ssoProvider,
+		Static:         static,
+		Templates:      templates,
+		TLSCert:        *tlsCert,
+		TLSKey:         *tlsKey,
+		UploadPolicy:   uploads,
 	}
 
 	// Pass the app.Routes() method (which returns a serve mux) to the
@@ -64,8 +271,8 @@ func main() {
 }
 
 // The connect() function wraps sql.Open() and returns a sql.DB connection pool
-// for a given DSN.
-func connect(dsn string) *sql.DB {
+// for a given DSN. The initial ping gives up after timeout (if non-zero).
+func connect(dsn string, timeout time.Duration) *sql.DB {
 
 	// sql.Open() it's a pool of many connections. Go manages these connections
 	// as needed, automatically opening and closing connections to the database
@@ -77,9 +284,41 @@ func connect(dsn string) *sql.DB {
 
 	// connections to the database are established lazily, as and when needed
 	// for the first time. So to verify that everything is set up correctly
-	// we use the db.Ping()
-	if err := db.Ping(); err != nil {
+	// we use the db.PingContext()
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := db.PingContext(ctx); err != nil {
 		log.Fatal(err)
 	}
 	return db
 }
+
+// newLogger returns a slog.Logger writing to w in the given format: "json",
+// or "logfmt" for slog's key=value text output.
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "logfmt":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// uiFS returns dir on disk if it's set, or else the given subdirectory of the
+// UI files embedded in the binary.
+func uiFS(dir, embedded string) (fs.FS, error) {
+	if dir != "" {
+		return os.DirFS(dir), nil
+	}
+	return fs.Sub(ui.Files, embedded)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bmizerany/pat"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the Prometheus collectors for the application. They are
+// registered on their own registry rather than the global one, so that only
+// what we choose ends up on /metrics.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	Requests        *prometheus.CounterVec
+	RequestDuration *prometheus.HistogramVec
+	RenderDuration  *prometheus.HistogramVec
+	SnippetsCreated prometheus.Counter
+	FailedLogins    prometheus.Counter
+}
+
+// NewMetrics creates and registers the application's metrics, including the
+// connection pool statistics of db.
+func NewMetrics(db *sql.DB) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_http_requests_total",
+			Help: "HTTP requests handled, by route pattern, method and status code.",
+		}, []string{"route", "method", "status"}),
+		RequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, by route pattern and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "status"}),
+		RenderDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_template_render_duration_seconds",
+			Help:    "Time taken to render HTML templates, by page.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1},
+		}, []string{"page"}),
+		SnippetsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "snippetbox_snippets_created_total",
+			Help: "Snippets created.",
+		}),
+		FailedLogins: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "snippetbox_failed_logins_total",
+			Help: "Login attempts rejected because of wrong credentials.",
+		}),
+	}
+	m.registry.MustRegister(
+		m.Requests, m.RequestDuration, m.RenderDuration, m.SnippetsCreated, m.FailedLogins,
+		collectors.NewDBStatsCollector(db, "snippetbox"),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+const contextKeyRoute = contextKey("route")
+
+// Instrument counts and times every request. The route label is the pat
+// pattern that matched (e.g. "/snippet/:id"), not the raw path, so that the
+// number of series stays bounded; requests no route matched are labelled
+// "unmatched".
+func (app *App) Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, route := withRouteHolder(r)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		status := strconv.Itoa(rec.status)
+		app.Metrics.Requests.WithLabelValues(*route, r.Method, status).Inc()
+		app.Metrics.RequestDuration.WithLabelValues(*route, status).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+// withRouteHolder returns the request with somewhere in its context for
+// withRoute to record the matched pattern, reusing the one an outer
+// middleware has already added if there is one.
+func withRouteHolder(r *http.Request) (*http.Request, *string) {
+	if route, ok := r.Context().Value(contextKeyRoute).(*string); ok {
+		return r, route
+	}
+	route := "unmatched"
+	return r.WithContext(context.WithValue(r.Context(), contextKeyRoute, &route)), &route
+}
+
+// routeMux wraps the pat router so that each handler, when it's picked,
+// reports the pattern it was registered with back to Instrument.
+type routeMux struct {
+	*pat.PatternServeMux
+}
+
+func (m routeMux) Get(pattern string, h http.Handler) {
+	m.PatternServeMux.Get(pattern, withRoute(pattern, h))
+}
+
+func (m routeMux) Post(pattern string, h http.Handler) {
+	m.PatternServeMux.Post(pattern, withRoute(pattern, h))
+}
+
+func withRoute(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(contextKeyRoute).(*string); ok {
+			*route = pattern
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,20 +1,108 @@
 package main
 
 import (
-	"log"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
 	"net/http"
+	"regexp"
+	"time"
 
 	"github.com/justinas/nosurf"
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+type contextKey string
+
+const contextKeyRequestID = contextKey("requestID")
+
+// rxRequestID limits which incoming X-Request-ID values we trust enough to
+// copy into our logs and response headers.
+var rxRequestID = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+// RequestID makes sure every request has an ID. It reuses the X-Request-ID
+// header set by a proxy in front of us if there is one, or makes one up
+// otherwise, then stores it in the request context and echoes it back in the
+// response so the client can quote it.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !rxRequestID.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), contextKeyRequestID, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// statusRecorder wraps a http.ResponseWriter to remember the status code and
+// the number of bytes written, which the handlers don't otherwise tell us.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
 // Our LogRequest middleware is a function that accepts the next handler
-// in a chain as a parameter. It executes some logic
-// (here, logging the request) and then calls the next handler.
-func LogRequest(next http.Handler) http.Handler {
+// in a chain as a parameter. It calls the next handler and then writes an
+// access log entry with the outcome: status, size, duration and, if someone
+// is logged in, their user ID.
+func (app *App) LogRequest(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		pattern := `%s - "%s %s %s"`
-		log.Printf(pattern, r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
-		next.ServeHTTP(w, r)
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		attrs := []slog.Attr{
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("proto", r.Proto),
+			slog.String("method", r.Method),
+			slog.String("uri", r.URL.RequestURI()),
+			slog.Int("status", rec.status),
+			slog.Int("bytes", rec.bytes),
+			slog.Duration("duration", time.Since(start)),
+		}
+		// The session cookie belongs to the request, so this is the user the
+		// request was made as (not, say, the one who just logged in).
+		if id, err := app.Sessions.Load(r).GetInt("currentUserID"); err == nil && id != 0 {
+			attrs = append(attrs, slog.Int("user_id", id))
+		}
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelError
+		}
+		app.requestLogger(r).LogAttrs(r.Context(), level, "request", attrs...)
 	}
 	return http.HandlerFunc(fn)
 	// If you call return before you call next.ServeHTTP()
@@ -23,47 +111,90 @@ func LogRequest(next http.Handler) http.Handler {
 	// (Common use-case for early returns is authentication middleware)
 }
 
-// SIMPLER LogRequest:
-// return http.HandlerFunc(
-// 	func(w http.ResponseWriter, r *http.Request) {
-// 		pattern := `%s - "%s %s %s"`
-// 		log.Printf(pattern, r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
-// 		next.ServeHTTP(w, r)
-// 	})
-
 func SecureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "deny")
-		w.Header()["X-XSS-Protection"] = []string{"1; mode=block"}
+		// The XSS auditor this turned on is gone from browsers, and could be
+		// abused where it remained; the CSP middleware covers this now.
+		w.Header()["X-XSS-Protection"] = []string{"0"}
+		// Don't leak snippet URLs to other sites via the Referer header.
+		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		// We don't use any of these, so no embedded content should either.
+		w.Header().Set("Permissions-Policy", "camera=(), microphone=(), geolocation=(), payment=(), usb=(), interest-cohort=()")
 		next.ServeHTTP(w, r)
 	})
 }
 
 func (app *App) RequireLogin(next http.Handler) http.Handler {
+	return app.RequireRole(models.RoleUser, next)
+}
+
+// RequireRole only lets the request through if the current user's role is at
+// least role. Anonymous users (and users whose account has been disabled
+// since they logged in) are sent to the login page; logged in users without
+// enough privileges get a 403 Forbidden.
+func (app *App) RequireRole(role models.Role, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Call the app.LoggedIn() helper to get the status for the current user.
-		loggedIn, err := app.LoggedIn(r)
+		ctx, span := tracer.Start(r.Context(), "RequireRole",
+			trace.WithAttributes(attribute.String("role", string(role))))
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		// Call the app.CurrentUser() helper to get the current user.
+		user, err := app.CurrentUser(r)
 		if err != nil {
-			app.ServerError(w, err)
+			app.ServerError(w, r, err)
 			return
 		}
 		// If they are not logged in, redirect them to the login page and return
 		// from the middleware chain so that no subsequent handlers in the chain
 		// are executed.
-		if !loggedIn {
+		if user == nil {
 			http.Redirect(w, r, "/user/login", 302)
 			return
 		}
+		if !user.Role.AtLeast(role) {
+			app.ClientError(w, http.StatusForbidden)
+			return
+		}
 		// Otherwise call the next handler in the chain.
 		next.ServeHTTP(w, r)
 	})
 }
 
+// ParseUpload parses a multipart form before the CSRF check, which would
+// otherwise read it first with no limit on its size. The body is capped at
+// app.maxUploadBody(), and a bigger one gets a 413 Request Entity Too Large.
+// Forms that aren't multipart are left for the handler.
+func (app *App) ParseUpload(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A body that big takes longer than the server's ReadTimeout to read,
+		// and storing the attachments takes about as long again.
+		maxBody := app.maxUploadBody()
+		read := time.Now().Add(transferTime(maxBody))
+		extendDeadlines(w, read, read.Add(transferTime(maxBody)))
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		err := r.ParseMultipartForm(1 << 20)
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			app.ClientError(w, http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil && err != http.ErrNotMultipart {
+			app.ClientError(w, http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Create a NoSurf middleware function which uses a customized CSRF cookie with
 // the Secure, Path and HttpOnly flags set.
 func NoSurf(next http.HandlerFunc) http.Handler {
-	csrfHandler := nosurf.New(next)
+	// NoSurf wraps all of the application handlers, which makes it a handy
+	// place to give each of them a span.
+	csrfHandler := nosurf.New(traceHandler(next))
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true, Path: "/", Secure: true,
 	})
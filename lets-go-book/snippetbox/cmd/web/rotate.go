@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/blob"
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
+)
+
+// rotateKeys runs the rotate-keys command. It can run while the site is up,
+// as long as the site has been restarted with the new key file first, so it
+// can read data wrapped with either key. Run it again after an interruption:
+// it picks up where it stopped.
+func rotateKeys(db *models.Database, blobs blob.Store) error {
+	if db.Keys == nil {
+		return errors.New("rotate-keys needs -encryption-keys")
+	}
+	slog.Info("rotating keys", slog.String("current", db.Keys.Current()))
+	r, err := db.RotateKeys(context.Background(), blobs)
+	if r != nil {
+		slog.Info("rotated keys",
+			slog.Int("snippets_rewrapped", r.SnippetsRewrapped),
+			slog.Int("snippets_encrypted", r.SnippetsEncrypted),
+			slog.Int("blobs_rewrapped", r.BlobsRewrapped),
+			slog.Int("blobs_encrypted", r.BlobsEncrypted))
+	}
+	return err
+}
@@ -4,27 +4,76 @@ import (
 	"net/http"
 
 	"github.com/bmizerany/pat"
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
 )
 
 // Change the signature so we're returning a http.Handler instead of a
 // *http.ServeMux.
 func (app *App) Routes() http.Handler {
-	mux := pat.New()
+	// routeMux records which pattern matched, for the request metrics.
+	mux := routeMux{pat.New()}
+
+	// Probes for the orchestrator. They don't need sessions or CSRF tokens.
+	mux.Get("/healthz", http.HandlerFunc(app.Healthz))
+	mux.Get("/readyz", http.HandlerFunc(app.Readyz))
+
+	// Browsers post CSP violation reports here, without a CSRF token.
+	mux.Post("/csp-report", http.HandlerFunc(app.CSPReport))
 
 	// The order of the handler calls matters.
 	mux.Get("/", NoSurf(app.Home))
 	mux.Get("/snippet/new", app.RequireLogin(NoSurf(app.NewSnippet)))
-	mux.Post("/snippet/new", app.RequireLogin(NoSurf(app.CreateSnippet)))
+	mux.Post("/snippet/new", app.RequireLogin(app.ParseUpload(NoSurf(app.CreateSnippet))))
 	mux.Get("/snippet/:id", NoSurf(app.ShowSnippet))
+	mux.Post("/snippet/:id/collect", app.RequireLogin(NoSurf(app.AddToCollection)))
+	mux.Get("/snippet/:id/fork", app.RequireLogin(NoSurf(app.ForkSnippet)))
+	mux.Get("/snippet/:id/diff", NoSurf(app.SnippetDiff))
+	mux.Get("/snippet/:id/zip", NoSurf(app.SnippetZip))
+	mux.Get("/snippet/:id/attachment/:attachment", NoSurf(app.ShowAttachment))
+	mux.Post("/snippet/:id/comments", app.RequireLogin(NoSurf(app.CreateComment)))
+	mux.Post("/snippet/:id/star", app.RequireLogin(NoSurf(app.ToggleStar)))
+	mux.Get("/comment/:id/edit", app.RequireLogin(NoSurf(app.EditComment)))
+	mux.Post("/comment/:id/edit", app.RequireLogin(NoSurf(app.UpdateComment)))
+	mux.Post("/comment/:id/delete", app.RequireLogin(NoSurf(app.DeleteComment)))
+	mux.Get("/tag/:name", NoSurf(app.ShowTag))
+	mux.Get("/collections", NoSurf(app.Collections))
+	mux.Post("/collections", app.RequireLogin(NoSurf(app.CreateCollection)))
+	mux.Get("/collection/:id", NoSurf(app.ShowCollection))
+	mux.Post("/collection/:id", app.RequireLogin(NoSurf(app.UpdateCollection)))
+	mux.Post("/collection/:id/delete", app.RequireLogin(NoSurf(app.DeleteCollection)))
+	mux.Post("/collection/:id/snippet/:snippet", app.RequireLogin(NoSurf(app.EditCollectionSnippet)))
 	mux.Get("/user/signup", NoSurf(app.SignupUser))
 	mux.Post("/user/signup", NoSurf(app.CreateUser))
 	mux.Get("/user/login", NoSurf(app.LoginUser))
 	mux.Post("/user/login", NoSurf(app.VerifyUser))
+	mux.Get("/user/login/sso", NoSurf(app.LoginSSO))
+	mux.Get("/user/login/sso/callback", NoSurf(app.SSOCallback))
+	mux.Post("/user/locale", NoSurf(app.SetLocale))
+	mux.Get("/user/settings", app.RequireLogin(NoSurf(app.UserSettings)))
+	mux.Get("/user/starred", app.RequireLogin(NoSurf(app.StarredSnippets)))
+	mux.Post("/user/settings", app.RequireLogin(NoSurf(app.SaveUserSettings)))
 	mux.Post("/user/logout", app.RequireLogin(NoSurf(app.LogoutUser)))
 
-	fileServer := http.FileServer(http.Dir(app.StaticDir))
-	mux.Get("/static/", http.StripPrefix("/static", fileServer))
+	// Moderators can remove and restore snippets, and remove comments; only
+	// admins manage users and read the audit log.
+	mux.Get("/admin", app.RequireRole(models.RoleModerator, NoSurf(app.AdminSnippets)))
+	mux.Post("/admin/snippet/:id/remove", app.RequireRole(models.RoleModerator, NoSurf(app.RemoveSnippet)))
+	mux.Post("/admin/snippet/:id/restore", app.RequireRole(models.RoleModerator, NoSurf(app.RestoreSnippet)))
+	mux.Post("/admin/comment/:id/remove", app.RequireRole(models.RoleModerator, NoSurf(app.RemoveComment)))
+	mux.Get("/admin/users", app.RequireRole(models.RoleAdmin, NoSurf(app.AdminUsers)))
+	mux.Post("/admin/user/:id/disable", app.RequireRole(models.RoleAdmin, NoSurf(app.DisableUser)))
+	mux.Post("/admin/user/:id/enable", app.RequireRole(models.RoleAdmin, NoSurf(app.EnableUser)))
+	mux.Post("/admin/user/:id/role", app.RequireRole(models.RoleAdmin, NoSurf(app.ChangeUserRole)))
+	mux.Get("/admin/audit", app.RequireRole(models.RoleAdmin, NoSurf(app.AdminAuditLog)))
+
+	mux.Get("/static/", http.StripPrefix("/static", app.Static))
+
+	// Unless metrics have their own listener (see RunServer), serve them here.
+	if app.MetricsAddr == "" {
+		mux.Get("/metrics", app.Metrics.Handler())
+	}
 
 	//return LogRequest(mux) // LogRequest → Router → Application Handler
-	return LogRequest(SecureHeaders(mux)) // LogRequest ↔ SecureHeaders ↔ Router ↔ Application Handler
+	// RequestID ↔ Trace ↔ LogRequest ↔ Instrument ↔ Compress ↔ SecureHeaders ↔ CSP ↔ Localize ↔ Router ↔ Application Handler
+	return RequestID(Trace(app.LogRequest(app.Instrument(Compress(SecureHeaders(app.CSP(app.Localize(mux))))))))
 }
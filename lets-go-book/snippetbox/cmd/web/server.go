@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -20,15 +24,76 @@ func (app *App) RunServer() {
 		Addr:      app.Addr,
 		Handler:   app.Routes(),
 		TLSConfig: tlsConfig,
+		// Send the server's own errors (TLS handshakes and the like) to our
+		// logger too.
+		ErrorLog: slog.NewLogLogger(app.Logger.Handler(), slog.LevelError),
 
-		// Fix vulnerability to slow-client atacks
+		// Fix vulnerability to slow-client atacks. Uploads and downloads of
+		// files extend their own deadlines (see extendDeadlines), as these
+		// are too short for them.
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
+	// Metrics can be kept off the public listener by giving them an address of
+	// their own, typically one only reachable from inside the network.
+	if app.MetricsAddr != "" {
+		go app.runMetricsServer()
+	}
+
 	// Call the http.Server's ListenAndServeTLS() method to start the server,
 	// passing in the paths to the TLS certificate and corresponding private key.
-	log.Printf("Starting server on %s", app.Addr)
+	// Shut down gracefully when asked to stop, so in-flight requests can
+	// finish instead of being cut off.
+	shutdownErr := make(chan error)
+	go app.waitForShutdown(srv, shutdownErr)
+
+	app.Logger.Info("starting server", slog.String("addr", app.Addr))
 	err := srv.ListenAndServeTLS(app.TLSCert, app.TLSKey)
-	log.Fatal(err)
+	// ListenAndServeTLS returns http.ErrServerClosed as soon as Shutdown() is
+	// called; anything else is a real failure.
+	if err != http.ErrServerClosed {
+		app.Logger.Error(err.Error())
+		os.Exit(1)
+	}
+	if err := <-shutdownErr; err != nil {
+		app.Logger.Error(err.Error())
+		os.Exit(1)
+	}
+	app.Logger.Info("stopped server")
+}
+
+// waitForShutdown blocks until SIGINT or SIGTERM, then marks the app as not
+// ready, waits ShutdownDelay for traffic to be routed elsewhere, and shuts
+// srv down. The result of the shutdown is sent on done.
+func (app *App) waitForShutdown(srv *http.Server, done chan<- error) {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+
+	app.Logger.Info("shutting down", slog.String("signal", sig.String()),
+		slog.Duration("delay", app.ShutdownDelay))
+	app.shuttingDown.Store(true)
+	time.Sleep(app.ShutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	done <- srv.Shutdown(ctx)
+}
+
+func (app *App) runMetricsServer() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", app.Metrics.Handler())
+	srv := &http.Server{
+		Addr:         app.MetricsAddr,
+		Handler:      mux,
+		ErrorLog:     slog.NewLogLogger(app.Logger.Handler(), slog.LevelError),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	app.Logger.Info("starting metrics server", slog.String("addr", app.MetricsAddr))
+	err := srv.ListenAndServe()
+	app.Logger.Error(err.Error())
+	os.Exit(1)
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/forms"
+)
+
+// UserSettings shows the settings page, which for now only holds the time
+// zone. The language is changed with the switcher on every page.
+func (app *App) UserSettings(w http.ResponseWriter, r *http.Request) {
+	session := app.Sessions.Load(r)
+	flash, err := session.PopString(w, "flash")
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	// RequireLogin has already checked there is a current user.
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.RenderHTML(w, r, "settingspage.html", &HTMLData{
+		Flash: flash,
+		Form:  forms.New(url.Values{"time_zone": {user.TimeZone}}, printer(r)),
+	})
+}
+
+func (app *App) SaveUserSettings(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	form := forms.New(r.PostForm, printer(r))
+	var settings forms.Settings
+	err = form.Decode(&settings)
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	if !settings.Valid(form) {
+		app.RenderHTML(w, r, "settingspage.html", &HTMLData{Form: form})
+		return
+	}
+
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	err = app.Database.SetUserTimeZone(r.Context(), user.ID, settings.TimeZone)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	session := app.Sessions.Load(r)
+	err = session.PutString(w, "timeZone", settings.TimeZone)
+	if err == nil {
+		err = session.PutString(w, "flash", printer(r).T("Your settings were saved."))
+	}
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
+	"github.com/noelruault/lets-go/snippetbox/pkg/sso"
+)
+
+// LoginSSO starts a single sign-on login. The state, nonce and PKCE verifier
+// are kept in the (encrypted) session cookie until the provider sends the
+// user back to SSOCallback.
+func (app *App) LoginSSO(w http.ResponseWriter, r *http.Request) {
+	if app.SSO == nil {
+		app.NotFound(w)
+		return
+	}
+	state, nonce, verifier := sso.NewState()
+	session := app.Sessions.Load(r)
+	for key, value := range map[string]string{
+		"ssoState": state, "ssoNonce": nonce, "ssoVerifier": verifier,
+	} {
+		err := session.PutString(w, key, value)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+	}
+	http.Redirect(w, r, app.SSO.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+func (app *App) SSOCallback(w http.ResponseWriter, r *http.Request) {
+	if app.SSO == nil {
+		app.NotFound(w)
+		return
+	}
+	// Pop the values saved by LoginSSO so that each can only be used once.
+	session := app.Sessions.Load(r)
+	values := map[string]string{"ssoState": "", "ssoNonce": "", "ssoVerifier": ""}
+	for key := range values {
+		value, err := session.PopString(w, key)
+		if err != nil {
+			app.ServerError(w, r, err)
+			return
+		}
+		values[key] = value
+	}
+
+	// The provider reports failures (like the user pressing cancel) with an
+	// error parameter instead of a code.
+	q := r.URL.Query()
+	if q.Get("error") != "" {
+		app.ssoFailed(w, r, "Single sign-on was cancelled or failed")
+		return
+	}
+	if values["ssoState"] == "" || q.Get("state") != values["ssoState"] {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	identity, err := app.SSO.Exchange(r.Context(), q.Get("code"), values["ssoNonce"],
+		values["ssoVerifier"])
+	if err == sso.ErrDomainNotAllowed {
+		app.ssoFailed(w, r, "Your email domain is not allowed to sign in")
+		return
+	} else if err == sso.ErrEmailNotVerified {
+		app.ssoFailed(w, r, "Your identity provider has not verified your email address")
+		return
+	} else if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	currentUserID, err := app.Database.UserForIdentity(r.Context(), identity.Issuer, identity.Subject,
+		identity.Email, identity.Name)
+	if err == models.ErrAccountDisabled {
+		app.ssoFailed(w, r, "Your account has been disabled")
+		return
+	} else if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+
+	err = session.PutInt(w, "currentUserID", currentUserID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	err = app.restorePreferences(w, r, currentUserID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/snippet/new", http.StatusSeeOther)
+}
+
+// ssoFailed sends the user back to the login page with a flash message,
+// which is translated.
+func (app *App) ssoFailed(w http.ResponseWriter, r *http.Request, msg string) {
+	session := app.Sessions.Load(r)
+	err := session.PutString(w, "flash", printer(r).T(msg))
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
+)
+
+// ToggleStar stars the snippet in the URL for the current user, or unstars
+// it if they already had, and goes back to it.
+func (app *App) ToggleStar(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.snippet(w, r)
+	if !ok {
+		return
+	}
+	// RequireLogin has already checked there is a current user.
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	_, err = app.Database.ToggleStar(r.Context(), user.ID, snippet.ID)
+	if err == models.ErrNoRecord {
+		// It expired or was removed since we looked it up.
+		app.NotFound(w)
+		return
+	} else if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", snippet.ID), http.StatusSeeOther)
+}
+
+// StarredSnippets lists the snippets the current user has starred.
+func (app *App) StarredSnippets(w http.ResponseWriter, r *http.Request) {
+	// RequireLogin has already checked there is a current user.
+	user, err := app.CurrentUser(r)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	snippets, err := app.Database.StarredSnippets(r.Context(), user.ID)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
+	}
+	app.RenderHTML(w, r, "starredpage.html", &HTMLData{Snippets: snippets})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// An asset is one static file, read into memory along with its compressed
+// variants.
+type asset struct {
+	name   string // The original path, e.g. "css/main.css".
+	hash   string // Hex prefix of the SHA-256 of the content.
+	body   []byte
+	gzip   []byte // nil if compressing didn't make it smaller.
+	brotli []byte
+}
+
+// Assets serves the static files. Every file can be fetched under its plain
+// name or under a fingerprinted one with a hash of its content in it (e.g.
+// css/main.3f9a1c07d2e4.css). The fingerprinted URL changes whenever the file
+// does, so it can be cached for good; the plain one has to be revalidated.
+//
+// Only files are served, never directory listings, and the whole set is read
+// once at startup: the static assets are small, and this lets us hash and
+// compress each of them just the once.
+type Assets struct {
+	byName map[string]*asset // Keyed by both the original and hashed paths.
+	hashed map[string]string // Original path to hashed path.
+}
+
+// NewAssets reads every file in fsys.
+func NewAssets(fsys fs.FS) (*Assets, error) {
+	a := &Assets{byName: map[string]*asset{}, hashed: map[string]string{}}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(body)
+		f := &asset{name: name, hash: hex.EncodeToString(sum[:6]), body: body}
+		if f.gzip, err = compressGzip(body); err != nil {
+			return err
+		}
+		if f.brotli, err = compressBrotli(body); err != nil {
+			return err
+		}
+
+		ext := path.Ext(name)
+		hashed := strings.TrimSuffix(name, ext) + "." + f.hash + ext
+		a.byName[name] = f
+		a.byName[hashed] = f
+		a.hashed[name] = hashed
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// URL returns the fingerprinted URL for the static file name. It's available
+// in the templates as {{asset "css/main.css"}}.
+func (a *Assets) URL(name string) (string, error) {
+	hashed, ok := a.hashed[strings.TrimPrefix(name, "/")]
+	if !ok {
+		return "", fmt.Errorf("no static asset %q", name)
+	}
+	return "/static/" + hashed, nil
+}
+
+// ServeHTTP serves the file named by the request path, which should already
+// have had /static stripped from it.
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	f, ok := a.byName[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if name == f.name {
+		// Caches may keep it, but must check it's still current each time.
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+
+	// Each encoding gets its own ETag, as the bytes differ.
+	body, etag := f.body, f.hash
+	switch {
+	case f.brotli != nil && acceptsEncoding(r, "br"):
+		body, etag = f.brotli, f.hash+"-br"
+		w.Header().Set("Content-Encoding", "br")
+	case f.gzip != nil && acceptsEncoding(r, "gzip"):
+		body, etag = f.gzip, f.hash+"-gz"
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	addVary(w.Header(), "Accept-Encoding")
+	w.Header().Set("ETag", `"`+etag+`"`)
+
+	// ServeContent takes care of If-None-Match, HEAD and ranges, and picks the
+	// Content-Type from the original file's extension.
+	http.ServeContent(w, r, path.Base(f.name), time.Time{}, bytes.NewReader(body))
+}
+
+// acceptsEncoding reports whether the request's Accept-Encoding header allows
+// the given content coding.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(part, ";")
+			if !strings.EqualFold(strings.TrimSpace(name), coding) {
+				continue
+			}
+			// A q-value of zero means "not this one".
+			q := strings.ReplaceAll(strings.TrimSpace(params), " ", "")
+			return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+		}
+	}
+	return false
+}
+
+// compressGzip returns body gzipped, or nil if that doesn't save anything
+// (images are already compressed, for instance).
+func compressGzip(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(body) {
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// compressBrotli is compressGzip for brotli.
+func compressBrotli(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	bw := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	if _, err := bw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := bw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(body) {
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A templateCache holds every page parsed once, up front, instead of
+// re-reading the files for each request. In dev mode it re-parses them
+// whenever a file has changed since the last parse, so template edits show
+// up on the next page load.
+type templateCache struct {
+	fsys  fs.FS
+	dev   bool
+	funcs template.FuncMap // Added to templateFuncs.
+
+	mu     sync.RWMutex
+	pages  map[string]*template.Template
+	parsed time.Time
+}
+
+// newTemplateCache parses all the pages in fsys. Any page that fails to
+// parse is an error, so a broken template stops the server from starting.
+// funcs holds template functions that need more than the package-level
+// templateFuncs, such as the asset URLs.
+func newTemplateCache(fsys fs.FS, dev bool, funcs template.FuncMap) (*templateCache, error) {
+	tc := &templateCache{fsys: fsys, dev: dev, funcs: funcs}
+	if err := tc.parse(); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// Get returns the template set for page (e.g. "homepage.html").
+func (tc *templateCache) Get(page string) (*template.Template, error) {
+	if tc.dev && tc.changed() {
+		if err := tc.parse(); err != nil {
+			return nil, err
+		}
+	}
+	tc.mu.RLock()
+	ts, ok := tc.pages[page]
+	tc.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("the template %s does not exist", page)
+	}
+	return ts, nil
+}
+
+// Len returns the number of pages in the cache.
+func (tc *templateCache) Len() int {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	return len(tc.pages)
+}
+
+func (tc *templateCache) parse() error {
+	files, err := fs.Glob(tc.fsys, "*.html")
+	if err != nil {
+		return err
+	}
+	// Partials hold {{define}} blocks shared by several pages, so every page
+	// gets all of them.
+	partials, err := fs.Glob(tc.fsys, "*.partial.html")
+	if err != nil {
+		return err
+	}
+
+	pages := map[string]*template.Template{}
+	for _, file := range files {
+		name := path.Base(file)
+		if name == "base.html" || strings.HasSuffix(name, ".partial.html") {
+			continue
+		}
+		patterns := append([]string{"base.html", file}, partials...)
+		// Our template.FuncMap must be registered with the template set before
+		// we parse the files, so we use template.New() to create an empty,
+		// unnamed, template set and register the functions on it first.
+		ts, err := template.New("").Funcs(templateFuncs).Funcs(tc.funcs).ParseFS(tc.fsys, patterns...)
+		if err != nil {
+			return err
+		}
+		pages[name] = ts
+	}
+	if len(pages) == 0 {
+		return fmt.Errorf("no pages found")
+	}
+
+	tc.mu.Lock()
+	tc.pages = pages
+	tc.parsed = time.Now()
+	tc.mu.Unlock()
+	return nil
+}
+
+// changed reports whether any template has been modified since the last
+// parse. Embedded files have no modification time, so they never change.
+func (tc *templateCache) changed() bool {
+	tc.mu.RLock()
+	parsed := tc.parsed
+	tc.mu.RUnlock()
+	files, err := fs.Glob(tc.fsys, "*.html")
+	if err != nil {
+		return true
+	}
+	for _, file := range files {
+		info, err := fs.Stat(tc.fsys, file)
+		if err != nil || info.ModTime().After(parsed) {
+			return true
+		}
+	}
+	return false
+}
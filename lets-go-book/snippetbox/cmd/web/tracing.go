@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The tracer goes through the global provider, so until SetupTracing is
+// called (or if tracing is off) spans cost next to nothing.
+var tracer = otel.Tracer("github.com/noelruault/lets-go/snippetbox/cmd/web")
+
+// NewSpanExporter returns the exporter named by kind: "otlp" sends spans to
+// an OpenTelemetry collector over HTTP (endpoint is host:port, and the usual
+// OTEL_EXPORTER_OTLP_* environment variables are honoured too), "stdout"
+// prints them, and "none" means tracing is off and nil is returned.
+func NewSpanExporter(ctx context.Context, kind, endpoint string, insecure bool) (sdktrace.SpanExporter, error) {
+	switch kind {
+	case "none", "":
+		return nil, nil
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(endpoint))
+		}
+		if insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	}
+	return nil, fmt.Errorf("unknown trace exporter %q", kind)
+}
+
+// SetupTracing installs a global tracer provider sending spans to exporter,
+// and the W3C trace context propagator so incoming traceparent headers are
+// honoured. Tests can pass a tracetest.InMemoryExporter. The returned provider
+// must be shut down before exiting, to flush any buffered spans.
+func SetupTracing(exporter sdktrace.SpanExporter) (*sdktrace.TracerProvider, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", "snippetbox"),
+	))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	return tp, nil
+}
+
+// Trace starts a server span for each request, continuing the trace from the
+// caller's traceparent header if there is one. It is named after the pat
+// pattern that matched once the router has run, like the request metrics.
+func Trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", r.RemoteAddr),
+			))
+		defer span.End()
+
+		if id, ok := r.Context().Value(contextKeyRequestID).(string); ok {
+			span.SetAttributes(attribute.String("http.request.id", id))
+		}
+
+		r, route := withRouteHolder(r.WithContext(ctx))
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		span.SetName(r.Method + " " + *route)
+		span.SetAttributes(
+			attribute.String("http.route", *route),
+			attribute.Int("http.response.status_code", rec.status),
+		)
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// traceHandler wraps an application handler in a span of its own, so its
+// time can be told apart from the middleware around it.
+func traceHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := "handler"
+		if route, ok := r.Context().Value(contextKeyRoute).(*string); ok {
+			name = "handler " + *route
+		}
+		ctx, span := tracer.Start(r.Context(), name)
+		defer span.End()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
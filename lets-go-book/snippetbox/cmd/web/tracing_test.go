@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bmizerany/pat"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp, err := SetupTracing(exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := routeMux{pat.New()}
+	mux.Get("/snippet/:id", traceHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})))
+
+	r := httptest.NewRequest("GET", "/snippet/1", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	Trace(mux).ServeHTTP(httptest.NewRecorder(), r)
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans; want 2", len(spans))
+	}
+	// The handler span ends first, so it's exported first.
+	handler, server := spans[0], spans[1]
+	if server.Name != "GET /snippet/:id" {
+		t.Errorf("server span name = %q", server.Name)
+	}
+	if got := server.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace ID = %s; want the one from traceparent", got)
+	}
+	if server.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("server span parent = %s; want the caller's span", server.Parent.SpanID())
+	}
+	if handler.Name != "handler /snippet/:id" || handler.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Errorf("handler span = %q, parent %s", handler.Name, handler.Parent.SpanID())
+	}
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"html/template"
 	"net/http"
-	"path/filepath"
 	"time"
 
 	"github.com/justinas/nosurf"
+	"github.com/noelruault/lets-go/snippetbox/pkg/diff"
+	"github.com/noelruault/lets-go/snippetbox/pkg/forms"
+	"github.com/noelruault/lets-go/snippetbox/pkg/i18n"
 	"github.com/noelruault/lets-go/snippetbox/pkg/models"
 )
 
@@ -17,17 +20,79 @@ func humanDate(t time.Time) string {
 	return t.Format("02 Jan 2006 at 15:04") // https://golang.org/pkg/time/#Time.Format
 }
 
+// Initialize a template.FuncMap object. This is essentially a string-keyed map
+// which acts as a lookup between the names of our custom template functions and
+// the functions themselves.
+//
+// RenderHTML replaces humanDate, relativeTime and t with versions for the
+// request's language and time zone; these are the English, UTC ones.
+var templateFuncs = template.FuncMap{
+	"byteSize":      byteSize,
+	"datetime":      datetime,
+	"fileLanguages": func() []string { return models.Languages },
+	"humanDate":     humanDate,
+	"relativeTime":  relativeTime,
+	"t":             (*i18n.Printer)(nil).T,
+}
+
+// datetime formats t for the datetime attribute of a <time> element.
+func datetime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// byteSize describes a file size in the largest unit that keeps it at least
+// 1, as in "1.5 MB".
+func byteSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	size := float64(n) / 1024
+	for _, unit := range []string{"KB", "MB"} {
+		if size < 1024 {
+			return fmt.Sprintf("%.1f %s", size, unit)
+		}
+		size /= 1024
+	}
+	return fmt.Sprintf("%.1f GB", size)
+}
+
+// relativeTime describes t relative to now, as in "in 3 hours".
+func relativeTime(t time.Time) string {
+	return (*i18n.Printer)(nil).Relative(t, time.Now())
+}
+
 // Define a new HTMLData struct to act as a wrapper for the dynamic data we want
 // to pass to our templates. For now this just contains the snippet data that we
 // want to display, which has the underling type *models.Snippet.
 type HTMLData struct {
-	CSRFToken string
-	Flash     string
-	Form      interface{}
-	LoggedIn  bool
-	Path      string
-	Snippet   *models.Snippet
-	Snippets  []*models.Snippet
+	AuditLog          models.AuditLog
+	Collection        *models.Collection
+	Collections       models.Collections // The logged in user's collections.
+	Comment           *models.Comment
+	Comments          models.Comments // The discussion on Snippet.
+	CSPNonce          string          // For nonce attributes on inline <script> and <style> tags.
+	CSRFToken         string
+	Diff              diff.Script   // From Parent to Snippet.
+	Files             []models.File // The files on the new snippet form.
+	Flash             string
+	Lang              string // The language tag of the page.
+	Form              *forms.Form
+	LoggedIn          bool
+	Parent            *models.Snippet // The snippet Snippet was forked from.
+	Path              string
+	PublicCollections models.Collections
+	Query             string
+	Snippet           *models.Snippet
+	SnippetHTML       template.HTML // Snippet rendered from Markdown, already sanitized.
+	Snippets          []*models.Snippet
+	Sort              string       // The homepage order: "" for the latest, or "popular".
+	SSO               bool         // Whether to offer single sign-on.
+	Starred           bool         // Whether the logged in user has starred Snippet.
+	Tag               string       // The tag being browsed.
+	Tags              models.Tags  // The tag cloud.
+	TimeZone          string       // The time zone dates are shown in.
+	User              *models.User // The logged in user, if any.
+	Users             models.Users
 }
 
 func (app *App) RenderHTML(
@@ -41,51 +106,60 @@ func (app *App) RenderHTML(
 
 	// Always add the CSRF token to the data for our templates.
 	data.CSRFToken = nosurf.Token(r)
+	data.CSPNonce = cspNonce(r)
+	p := printer(r)
+	data.Lang = p.Lang()
+	loc := location(r)
+	data.TimeZone = loc.String()
 
-	// Add the logged in status to the HTMLData.
+	// Add the logged in user and status to the HTMLData.
 	var err error
-	data.LoggedIn, err = app.LoggedIn(r)
+	data.User, err = app.CurrentUser(r)
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
+	data.LoggedIn = data.User != nil
+	data.SSO = app.SSO != nil
 
-	files := []string{
-		filepath.Join(app.HTMLDir, "base.html"),
-		filepath.Join(app.HTMLDir, page),
-	}
-
-	// Initialize a template.FuncMap object. This is essentially a string-keyed map
-	// which acts as a lookup between the names of our custom template functions and
-	// the functions themselves.
-	fm := template.FuncMap{
-		"humanDate": humanDate,
+	ts, err := app.Templates.Get(page)
+	if err != nil {
+		app.ServerError(w, r, err)
+		return
 	}
-
-	// Our template.FuncMap must be registered with the template set before we call
-	// the ParseFiles() method. This means we have to use template.New() to create
-	// an empty, unnamed, template set, use the Funcs() method to register our
-	// template.FuncMap, and then parse the files as normal.
-	ts, err := template.New("").Funcs(fm).ParseFiles(files...) // WITH FUNCTIONS
-	// ts, err := template.ParseFiles(files...) // WITHOUT FUNCTIONS
+	// Work on a copy, so the cached set can be cloned again for the next
+	// request, and translate it for this one.
+	ts, err = ts.Clone()
 	if err != nil {
-		app.ServerError(w, err)
+		app.ServerError(w, r, err)
 		return
 	}
+	ts.Funcs(template.FuncMap{
+		"humanDate":    func(t time.Time) string { return p.Date(t.In(loc)) },
+		"relativeTime": func(t time.Time) string { return p.Relative(t, time.Now()) },
+		"t":            p.T,
+	})
 
 	buf := new(bytes.Buffer)
 	// Write the template to the buffer, instead of straight to the
 	// http.ResponseWriter. If there's an error, call our error handler and then
 	// return.
+	_, span := tracer.Start(r.Context(), "render "+page)
+	start := time.Now()
 	err = ts.ExecuteTemplate(buf, "base", data)
+	app.Metrics.RenderDuration.WithLabelValues(page).Observe(time.Since(start).Seconds())
+	span.End()
 	if err != nil {
-		app.ServerError(w, err) // Use the app.ServerError() helper.
+		app.ServerError(w, r, err) // Use the app.ServerError() helper.
 		return
 	}
 
+	// Say what it is up front, rather than leaving net/http (or the Compress
+	// middleware) to sniff it.
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
 	// Write the contents of the buffer to the http.ResponseWriter. Again, this
 	// is another time where we pass our http.ResponseWriter to a function that
 	// takes an io.Writer.
 	buf.WriteTo(w)
-
 }
@@ -0,0 +1,25 @@
+-- Roles on users, account disabling, soft-deleted snippets and an audit log of
+-- moderation actions.
+
+ALTER TABLE users
+    ADD COLUMN role ENUM('user', 'moderator', 'admin') NOT NULL DEFAULT 'user',
+    ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- A removed snippet is hidden everywhere but kept so it can be restored.
+ALTER TABLE snippets ADD COLUMN removed DATETIME NULL;
+
+CREATE TABLE audit_log (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    actor_id INTEGER NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    target_type VARCHAR(50) NOT NULL,
+    target_id INTEGER NOT NULL,
+    detail VARCHAR(255) NOT NULL DEFAULT '',
+    created DATETIME NOT NULL,
+    FOREIGN KEY (actor_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_audit_log_created ON audit_log(created);
+
+-- Promote the first admin by hand, e.g.:
+-- UPDATE users SET role = 'admin' WHERE email = 'you@example.com';
@@ -0,0 +1,2 @@
+-- argon2id hashes are longer than the 60 characters of a bcrypt hash.
+ALTER TABLE users MODIFY password VARCHAR(255) NOT NULL;
@@ -0,0 +1,15 @@
+-- Record which migrations have been applied, so the application can check at
+-- runtime that the schema is recent enough. Every migration from here on ends
+-- by inserting its own number.
+
+CREATE TABLE schema_migrations (
+    version INTEGER NOT NULL PRIMARY KEY,
+    applied DATETIME NOT NULL
+);
+
+INSERT INTO schema_migrations (version, applied) VALUES
+    (1, UTC_TIMESTAMP()),
+    (2, UTC_TIMESTAMP()),
+    (3, UTC_TIMESTAMP()),
+    (4, UTC_TIMESTAMP()),
+    (5, UTC_TIMESTAMP());
@@ -0,0 +1,6 @@
+-- The language a user picked with the language switcher, as a language tag
+-- (e.g. "es"). Empty means whatever their browser asks for.
+
+ALTER TABLE users ADD COLUMN locale VARCHAR(35) NOT NULL DEFAULT '';
+
+INSERT INTO schema_migrations (version, applied) VALUES (6, UTC_TIMESTAMP());
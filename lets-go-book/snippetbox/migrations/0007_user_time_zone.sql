@@ -0,0 +1,6 @@
+-- The IANA time zone (e.g. "Europe/Madrid") a user wants dates shown in.
+-- Empty means whatever their browser reports.
+
+ALTER TABLE users ADD COLUMN time_zone VARCHAR(64) NOT NULL DEFAULT '';
+
+INSERT INTO schema_migrations (version, applied) VALUES (7, UTC_TIMESTAMP());
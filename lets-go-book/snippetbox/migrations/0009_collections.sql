@@ -0,0 +1,28 @@
+-- Collections: named, ordered groups of snippets put together by a user, such
+-- as a runbook. visibility is who can see a collection: anyone, and listed on
+-- /collections ("public"); anyone with the link ("unlisted"); or only its
+-- owner ("private").
+
+CREATE TABLE collections (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    visibility ENUM('public', 'unlisted', 'private') NOT NULL DEFAULT 'private',
+    created DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_collections_user_id ON collections(user_id);
+CREATE INDEX idx_collections_visibility_created ON collections(visibility, created);
+
+-- position orders the snippets within a collection, lowest first.
+CREATE TABLE collection_snippets (
+    collection_id INTEGER NOT NULL,
+    snippet_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (collection_id, snippet_id),
+    FOREIGN KEY (collection_id) REFERENCES collections(id) ON DELETE CASCADE,
+    FOREIGN KEY (snippet_id) REFERENCES snippets(id)
+);
+
+INSERT INTO schema_migrations (version, applied) VALUES (9, UTC_TIMESTAMP());
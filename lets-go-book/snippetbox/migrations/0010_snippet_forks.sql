@@ -0,0 +1,12 @@
+-- Snippets now record who created them, and the snippet they were forked
+-- from, if any. Snippets created before this migration have no owner.
+
+ALTER TABLE snippets
+    ADD COLUMN user_id INTEGER NULL,
+    ADD COLUMN parent_id INTEGER NULL,
+    ADD FOREIGN KEY (user_id) REFERENCES users(id),
+    ADD FOREIGN KEY (parent_id) REFERENCES snippets(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_snippets_parent_id ON snippets(parent_id);
+
+INSERT INTO schema_migrations (version, applied) VALUES (10, UTC_TIMESTAMP());
@@ -0,0 +1,24 @@
+-- Comments on snippets. A comment can reply to another comment on the same
+-- snippet (parent_id), and can be about one line of the snippet (line, counted
+-- from 1) rather than the whole thing. Deleted comments (by their author) and
+-- removed ones (by a moderator) are kept, so replies to them still make sense.
+
+CREATE TABLE comments (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    parent_id INTEGER NULL,
+    line INTEGER NULL,
+    body TEXT NOT NULL,
+    created DATETIME NOT NULL,
+    edited DATETIME NULL,
+    deleted DATETIME NULL,
+    removed DATETIME NULL,
+    FOREIGN KEY (snippet_id) REFERENCES snippets(id),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (parent_id) REFERENCES comments(id)
+);
+
+CREATE INDEX idx_comments_snippet_id ON comments(snippet_id, created);
+
+INSERT INTO schema_migrations (version, applied) VALUES (11, UTC_TIMESTAMP());
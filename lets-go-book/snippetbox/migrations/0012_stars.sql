@@ -0,0 +1,19 @@
+-- Users can star snippets. snippets.stars keeps the count, so showing it
+-- doesn't mean counting rows; the index on stars(created) lets the popular
+-- listing look at recent stars only.
+
+CREATE TABLE stars (
+    user_id INTEGER NOT NULL,
+    snippet_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (user_id, snippet_id),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (snippet_id) REFERENCES snippets(id)
+);
+
+CREATE INDEX idx_stars_snippet_id ON stars(snippet_id);
+CREATE INDEX idx_stars_created ON stars(created, snippet_id);
+
+ALTER TABLE snippets ADD COLUMN stars INTEGER NOT NULL DEFAULT 0;
+
+INSERT INTO schema_migrations (version, applied) VALUES (12, UTC_TIMESTAMP());
@@ -0,0 +1,8 @@
+-- How a snippet's content is shown: as code with line numbers (how every
+-- snippet was shown until now), as plain wrapped text, or rendered from
+-- Markdown.
+
+ALTER TABLE snippets
+    ADD COLUMN format ENUM('plain', 'code', 'markdown') NOT NULL DEFAULT 'code';
+
+INSERT INTO schema_migrations (version, applied) VALUES (13, UTC_TIMESTAMP());
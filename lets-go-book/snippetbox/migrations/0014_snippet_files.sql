@@ -0,0 +1,15 @@
+-- Besides its content, a snippet can have named files, such as the config
+-- that goes with a Go file. position keeps them in the order they were given.
+
+CREATE TABLE snippet_files (
+    snippet_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    language VARCHAR(20) NOT NULL,
+    content MEDIUMTEXT NOT NULL,
+    PRIMARY KEY (snippet_id, position),
+    UNIQUE KEY (snippet_id, name),
+    FOREIGN KEY (snippet_id) REFERENCES snippets(id)
+);
+
+INSERT INTO schema_migrations (version, applied) VALUES (14, UTC_TIMESTAMP());
@@ -0,0 +1,19 @@
+-- Attachments are uploaded files. Their bytes live in the blob store (see
+-- pkg/blob) under blob_key, the SHA-256 of the contents, so several
+-- attachments can share one blob; only the details are kept here.
+
+CREATE TABLE attachments (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL,
+    blob_key CHAR(64) NOT NULL,
+    created DATETIME NOT NULL,
+    FOREIGN KEY (snippet_id) REFERENCES snippets(id)
+);
+
+CREATE INDEX idx_attachments_snippet ON attachments(snippet_id);
+CREATE INDEX idx_attachments_blob_key ON attachments(blob_key);
+
+INSERT INTO schema_migrations (version, applied) VALUES (15, UTC_TIMESTAMP());
@@ -0,0 +1,23 @@
+-- Snippet content, files and attachments can be encrypted at rest (see
+-- pkg/models/keys.go). An encrypted snippet's content and files hold base64
+-- ciphertext, which is bigger than what it replaces, and the snippet has the
+-- data key it was encrypted with, wrapped with the key named by key_id.
+-- Snippets from before have neither, until rotate-keys encrypts them.
+
+ALTER TABLE snippets
+    MODIFY content MEDIUMTEXT NOT NULL,
+    ADD COLUMN key_id VARCHAR(32) NULL,
+    ADD COLUMN data_key VARBINARY(128) NULL;
+
+CREATE INDEX idx_snippets_key_id ON snippets(key_id);
+
+-- An attachment blob's data key, likewise. Blobs are shared between
+-- attachments (see 0015), so the key goes with the blob.
+CREATE TABLE blob_keys (
+    blob_key CHAR(64) NOT NULL PRIMARY KEY,
+    key_id VARCHAR(32) NOT NULL,
+    data_key VARBINARY(128) NOT NULL,
+    INDEX idx_blob_keys_key_id (key_id)
+);
+
+INSERT INTO schema_migrations (version, applied) VALUES (16, UTC_TIMESTAMP());
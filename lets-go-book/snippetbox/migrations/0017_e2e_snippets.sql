@@ -0,0 +1,7 @@
+-- An end-to-end encrypted snippet's content is ciphertext made in the
+-- author's browser, with a key the server never sees; readers' browsers
+-- decrypt it with the key from the link.
+
+ALTER TABLE snippets ADD COLUMN e2e BOOLEAN NOT NULL DEFAULT FALSE;
+
+INSERT INTO schema_migrations (version, applied) VALUES (17, UTC_TIMESTAMP());
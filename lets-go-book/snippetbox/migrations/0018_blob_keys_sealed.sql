@@ -0,0 +1,8 @@
+-- sealed records that a blob has been written encrypted with its data key.
+-- The key is stored first, so a blob whose write failed (or was cut short)
+-- still has sealed = FALSE, and rotate-keys encrypts it again. Blobs keyed
+-- before this column was added are checked the same way.
+
+ALTER TABLE blob_keys ADD COLUMN sealed BOOLEAN NOT NULL DEFAULT FALSE;
+
+INSERT INTO schema_migrations (version, applied) VALUES (18, UTC_TIMESTAMP());
@@ -0,0 +1,70 @@
+// Package blob stores uploaded files outside the database, either in a local
+// directory or in an S3-compatible bucket. Blobs are content addressed: a
+// blob's key is the SHA-256 of its bytes (or a keyed hash of them, see
+// Hasher), so the same file uploaded twice is only stored once.
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"regexp"
+)
+
+// ErrNotFound is returned when there is no blob with the key asked for.
+var ErrNotFound = errors.New("blob: not found")
+
+// A Store keeps blobs by key. Keys are always made by Save, so a Store can
+// trust them to be lowercase hex.
+type Store interface {
+	// Exists reports whether there is a blob with the key.
+	Exists(ctx context.Context, key string) (bool, error)
+	// Put stores size bytes read from r under the key, replacing any blob
+	// already there.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Open returns the blob's contents, or ErrNotFound. The caller must close
+	// it.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// A Hasher is a Store that has Save key its blobs with its own hash rather
+// than a plain SHA-256, for instance an HMAC under a secret, so that the keys
+// don't give away which files are stored. The hash must be 32 bytes long.
+type Hasher interface {
+	NewHash() hash.Hash
+}
+
+var keyRE = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ValidKey reports whether key could have been made by Save.
+func ValidKey(key string) bool {
+	return keyRE.MatchString(key)
+}
+
+// Save hashes r to find its key, and stores it unless the store already has
+// it. It returns the key and the size of the blob. r is read twice, hence the
+// io.ReadSeeker; an uploaded multipart.File is one.
+func Save(ctx context.Context, s Store, r io.ReadSeeker) (key string, size int64, err error) {
+	h := sha256.New()
+	if hasher, ok := s.(Hasher); ok {
+		h = hasher.NewHash()
+	}
+	size, err = io.Copy(h, r)
+	if err != nil {
+		return "", 0, err
+	}
+	key = hex.EncodeToString(h.Sum(nil))
+
+	exists, err := s.Exists(ctx, key)
+	if err != nil || exists {
+		return key, size, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", 0, err
+	}
+	return key, size, s.Put(ctx, key, r, size)
+}
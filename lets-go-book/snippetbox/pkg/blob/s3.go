@@ -0,0 +1,201 @@
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config holds the settings for an S3-compatible bucket.
+type S3Config struct {
+	// Endpoint is the service's base URL, such as https://s3.eu-west-1.amazonaws.com
+	// or http://localhost:9000 for MinIO. Buckets are addressed by path
+	// (Endpoint/Bucket/key), which every S3-compatible service supports.
+	Endpoint  string
+	Bucket    string
+	Region    string // Defaults to us-east-1.
+	AccessKey string
+	SecretKey string
+	// Prefix is put in front of every key, to share a bucket with other data.
+	Prefix string
+	// Client makes the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// S3 stores blobs in an S3-compatible bucket. It only needs three of the S3
+// API's calls (PUT, GET and HEAD of an object), so rather than pull in an SDK
+// it makes them itself, signed with AWS Signature Version 4.
+type S3 struct {
+	config S3Config
+	base   *url.URL
+}
+
+// NewS3 returns a store for the bucket. It doesn't contact the service.
+func NewS3(c S3Config) (*S3, error) {
+	base, err := url.Parse(strings.TrimSuffix(c.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" || base.Host == "" {
+		return nil, fmt.Errorf("blob: S3 endpoint %q is not an http(s) URL", c.Endpoint)
+	}
+	if c.Bucket == "" {
+		return nil, fmt.Errorf("blob: no S3 bucket given")
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	if c.Client == nil {
+		c.Client = http.DefaultClient
+	}
+	return &S3{config: c, base: base}, nil
+}
+
+func (s *S3) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, s.error(resp, http.MethodHead, key)
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	resp, err := s.do(ctx, http.MethodPut, key, r, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s.error(resp, http.MethodPut, key)
+	}
+	return nil
+}
+
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	defer resp.Body.Close()
+	return nil, s.error(resp, http.MethodGet, key)
+}
+
+// do sends a signed request for the object with the key.
+func (s *S3) do(ctx context.Context, method, key string, body io.Reader, size int64) (*http.Response, error) {
+	u := *s.base
+	u.Path += "/" + s.config.Bucket + "/" + s.config.Prefix + key
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// The body isn't hashed (it may be large, and is streamed), which S3
+	// allows when it says so in x-amz-content-sha256.
+	payloadHash := "UNSIGNED-PAYLOAD"
+	if body != nil {
+		req.ContentLength = size
+		req.Body = io.NopCloser(body)
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	} else {
+		payloadHash = emptyHash
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	sign(req, payloadHash, s.config.AccessKey, s.config.SecretKey, s.config.Region, time.Now())
+	return s.config.Client.Do(req)
+}
+
+// error describes a failed response, including the start of its body, where
+// S3 puts an XML document saying what went wrong.
+func (s *S3) error(resp *http.Response, method, key string) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("blob: S3 %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// emptyHash is the SHA-256 of no bytes, the payload hash of a request with
+// no body.
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds an AWS Signature Version 4 Authorization header to req, covering
+// its host and every header already set on it. See
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func sign(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	// The canonical headers: lowercased names in order, with trimmed values.
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexHash(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
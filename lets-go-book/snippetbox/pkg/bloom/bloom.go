@@ -0,0 +1,105 @@
+// Package bloom implements a small Bloom filter, used to check passwords
+// against a list of known breached passwords without keeping the whole list
+// in memory.
+package bloom
+
+import (
+	"bufio"
+	"hash/fnv"
+	"math"
+	"os"
+	"strings"
+)
+
+// A Filter answers "have I seen this string?" with no false negatives and a
+// tunable rate of false positives.
+type Filter struct {
+	bits []uint64
+	m    uint64 // number of bits
+	k    uint64 // number of hash functions
+}
+
+// New returns a Filter sized to hold n items with the given false positive
+// rate (e.g. 0.001 for one in a thousand).
+func New(n int, fpRate float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// Add records s in the filter.
+func (f *Filter) Add(s string) {
+	h1, h2 := hashes(s)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// Test reports whether s may have been added to the filter. A false result
+// is always right; a true result is wrong at roughly the configured rate.
+func (f *Filter) Test(s string) bool {
+	h1, h2 := hashes(s)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadFile builds a Filter from a text file with one entry per line. Blank
+// lines are skipped.
+func LoadFile(path string, fpRate float64) (*Filter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Read the file twice: once to count the lines so the filter can be sized,
+	// and once to fill it. This keeps memory use to the filter itself.
+	n := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) != "" {
+			n++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	f := New(n, fpRate)
+	scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			f.Add(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// hashes derives the two base hashes used for double hashing (Kirsch and
+// Mitzenmacher): the i-th hash function is h1 + i*h2.
+func hashes(s string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	h1 := h.Sum64()
+	h.Write([]byte{0})
+	h2 := h.Sum64() | 1 // odd, so it never cycles on a power-of-two m
+	return h1, h2
+}
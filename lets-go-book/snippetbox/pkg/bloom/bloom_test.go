@@ -0,0 +1,67 @@
+package bloom
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	const n = 1000
+	f := New(n, 0.01)
+	for i := 0; i < n; i++ {
+		f.Add(fmt.Sprint("in", i))
+	}
+	for i := 0; i < n; i++ {
+		if !f.Test(fmt.Sprint("in", i)) {
+			t.Fatalf("Test(in%d) = false; a Bloom filter has no false negatives", i)
+		}
+	}
+
+	// Allow a generous margin over the 1% asked for.
+	falsePositives := 0
+	for i := 0; i < n*10; i++ {
+		if f.Test(fmt.Sprint("out", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / (n * 10); rate > 0.03 {
+		t.Errorf("false positive rate = %.3f; want about 0.01", rate)
+	}
+}
+
+func TestNewTiny(t *testing.T) {
+	f := New(0, 0.5)
+	if f.k < 1 || f.m < 1 {
+		t.Fatalf("New(0, 0.5) has m = %d, k = %d", f.m, f.k)
+	}
+	f.Add("x")
+	if !f.Test("x") {
+		t.Error("Test(x) = false after Add(x)")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	err := os.WriteFile(path, []byte("password\n\n  123456  \nqwerty\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := LoadFile(path, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"password", "123456", "qwerty"} {
+		if !f.Test(s) {
+			t.Errorf("Test(%q) = false; it's in the file", s)
+		}
+	}
+	if f.Test("correct horse battery staple") {
+		t.Error("Test found a password that isn't in the file (possible, but unlikely at 0.001)")
+	}
+
+	if _, err := LoadFile(filepath.Join(t.TempDir(), "missing"), 0.001); err == nil {
+		t.Error("LoadFile of a missing file succeeded")
+	}
+}
@@ -0,0 +1,170 @@
+// Package diff compares two texts line by line, for showing how a forked
+// snippet differs from the one it was forked from.
+package diff
+
+import "strings"
+
+// Op says what happened to a line.
+type Op int
+
+const (
+	Equal  Op = iota // In both texts.
+	Insert           // Only in the new text.
+	Delete           // Only in the old text.
+)
+
+// String returns "equal", "insert" or "delete", which the templates use as
+// CSS classes.
+func (op Op) String() string {
+	switch op {
+	case Insert:
+		return "insert"
+	case Delete:
+		return "delete"
+	}
+	return "equal"
+}
+
+// A Line is one line of a Script.
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// A Script is the list of lines that turns one text into another.
+type Script []Line
+
+// Added returns the number of inserted lines.
+func (s Script) Added() int { return s.count(Insert) }
+
+// Removed returns the number of deleted lines.
+func (s Script) Removed() int { return s.count(Delete) }
+
+func (s Script) count(op Op) int {
+	n := 0
+	for _, l := range s {
+		if l.Op == op {
+			n++
+		}
+	}
+	return n
+}
+
+// maxEdits bounds the work Compare does. Past this many inserted and deleted
+// lines it gives up looking for the shortest script, and reports the changed
+// part of the text as deleted and inserted wholesale.
+const maxEdits = 500
+
+// Compare returns a shortest script turning old into new, found with Myers'
+// algorithm ("An O(ND) Difference Algorithm and Its Variations", 1986).
+func Compare(old, new string) Script {
+	a, b := split(old), split(new)
+
+	// Most forks change a few lines, so take the common start and end off
+	// first and only search the middle.
+	var script Script
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		script = append(script, Line{Equal, a[0]})
+		a, b = a[1:], b[1:]
+	}
+	var tail Script
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		tail = append(tail, Line{Equal, a[len(a)-1]})
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	script = append(script, myers(a, b)...)
+	for i := len(tail) - 1; i >= 0; i-- {
+		script = append(script, tail[i])
+	}
+	return script
+}
+
+// split breaks text into lines, ignoring a final newline and \r\n endings.
+func split(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+func myers(a, b []string) Script {
+	n, m := len(a), len(b)
+	max := n + m
+	if max > maxEdits {
+		max = maxEdits
+	}
+	// v[offset+k] is the furthest x reached on diagonal k (where k = x - y).
+	// trace[d] is v as it was before looking for a script of length d, which
+	// is what walking back from the end needs.
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Down from diagonal k+1: an insert.
+			} else {
+				x = v[offset+k-1] + 1 // Right from diagonal k-1: a delete.
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, offset, a, b)
+			}
+		}
+	}
+
+	// Too different to be worth the search.
+	script := make(Script, 0, n+m)
+	for _, line := range a {
+		script = append(script, Line{Delete, line})
+	}
+	for _, line := range b {
+		script = append(script, Line{Insert, line})
+	}
+	return script
+}
+
+// backtrack walks the trace from the end of both texts back to the start,
+// collecting the script in reverse.
+func backtrack(trace [][]int, offset int, a, b []string) Script {
+	x, y := len(a), len(b)
+	var script Script
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			script = append(script, Line{Equal, a[x-1]})
+			x, y = x-1, y-1
+		}
+		if x == prevX {
+			script = append(script, Line{Insert, b[y-1]})
+			y--
+		} else {
+			script = append(script, Line{Delete, a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		script = append(script, Line{Equal, a[x-1]})
+		x, y = x-1, y-1
+	}
+	for i, j := 0, len(script)-1; i < j; i, j = i+1, j-1 {
+		script[i], script[j] = script[j], script[i]
+	}
+	return script
+}
@@ -0,0 +1,145 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     Script
+	}{
+		{"both empty", "", "", nil},
+		{"equal", "a\nb\n", "a\nb\n", Script{{Equal, "a"}, {Equal, "b"}}},
+		{"pure insert", "", "a\nb", Script{{Insert, "a"}, {Insert, "b"}}},
+		{"pure delete", "a\nb", "", Script{{Delete, "a"}, {Delete, "b"}}},
+		{"common prefix and suffix", "a\nb\nc\nd", "a\nx\nd",
+			Script{{Equal, "a"}, {Delete, "b"}, {Delete, "c"}, {Insert, "x"}, {Equal, "d"}}},
+		{"insert in the middle", "a\nc", "a\nb\nc", Script{{Equal, "a"}, {Insert, "b"}, {Equal, "c"}}},
+		{"delete at the end", "a\nb\nc", "a\nb", Script{{Equal, "a"}, {Equal, "b"}, {Delete, "c"}}},
+		{"CRLF against LF", "a\r\nb\r\n", "a\nb", Script{{Equal, "a"}, {Equal, "b"}}},
+		{"final newline ignored", "a\nb", "a\nb\n", Script{{Equal, "a"}, {Equal, "b"}}},
+		{"blank line is a line", "a\n\nb", "a\nb", Script{{Equal, "a"}, {Delete, ""}, {Equal, "b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Compare(tt.old, tt.new)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Compare(%q, %q) = %v; want %v", tt.old, tt.new, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScriptCounts(t *testing.T) {
+	s := Compare("a\nb\nc", "a\nx\ny\nc")
+	if s.Added() != 2 || s.Removed() != 1 {
+		t.Errorf("Added, Removed = %d, %d; want 2, 1", s.Added(), s.Removed())
+	}
+}
+
+// apply rebuilds the old and new texts from a script.
+func apply(s Script) (old, new []string) {
+	for _, l := range s {
+		if l.Op != Insert {
+			old = append(old, l.Text)
+		}
+		if l.Op != Delete {
+			new = append(new, l.Text)
+		}
+	}
+	return old, new
+}
+
+// lcs returns the length of the longest common subsequence of a and b. A
+// shortest script keeps exactly that many lines.
+func lcs(a, b []string) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				dp[i][j] = dp[i+1][j+1] + 1
+			case dp[i+1][j] > dp[i][j+1]:
+				dp[i][j] = dp[i+1][j]
+			default:
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+	return dp[0][0]
+}
+
+// Compare random texts from a small alphabet, which have lots of ways to
+// line up, against a brute-force shortest script length.
+func TestCompareShortest(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	lines := func() []string {
+		out := make([]string, r.Intn(12))
+		for i := range out {
+			out[i] = string(rune('a' + r.Intn(4)))
+		}
+		return out
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := lines(), lines()
+		s := Compare(strings.Join(a, "\n"), strings.Join(b, "\n"))
+		gotA, gotB := apply(s)
+		if !reflect.DeepEqual(gotA, nilIfEmpty(a)) || !reflect.DeepEqual(gotB, nilIfEmpty(b)) {
+			t.Fatalf("Compare(%q, %q) = %v, which doesn't rebuild them", a, b, s)
+		}
+		if edits, want := s.Added()+s.Removed(), len(a)+len(b)-2*lcs(a, b); edits != want {
+			t.Fatalf("Compare(%q, %q) = %v, with %d edits; want %d", a, b, s, edits, want)
+		}
+	}
+}
+
+func nilIfEmpty(s []string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
+
+// Past maxEdits, Compare gives up on the shortest script. It still keeps the
+// common start and end, and the script still rebuilds both texts, but lines
+// in common in the middle are deleted and inserted again.
+func TestCompareMaxEdits(t *testing.T) {
+	var a, b []string
+	for i := 0; i < maxEdits; i++ {
+		a = append(a, fmt.Sprint("old", i))
+		b = append(b, fmt.Sprint("new", i))
+		if i == maxEdits/2 {
+			a = append(a, "middle")
+			b = append(b, "middle")
+		}
+	}
+	a = append(append([]string{"first"}, a...), "last")
+	b = append(append([]string{"first"}, b...), "last")
+	s := Compare(strings.Join(a, "\n"), strings.Join(b, "\n"))
+
+	gotA, gotB := apply(s)
+	if !reflect.DeepEqual(gotA, a) || !reflect.DeepEqual(gotB, b) {
+		t.Fatal("the script doesn't rebuild the texts")
+	}
+	if s[0] != (Line{Equal, "first"}) || s[len(s)-1] != (Line{Equal, "last"}) {
+		t.Errorf("script runs from %v to %v; want the common start and end kept", s[0], s[len(s)-1])
+	}
+	if s.Added() != len(b)-2 || s.Removed() != len(a)-2 {
+		t.Errorf("Added, Removed = %d, %d; want everything but the ends (%d)", s.Added(), s.Removed(), len(a)-2)
+	}
+
+	// Well within the limit, the middle line is kept.
+	small := Compare("old1\nmiddle\nold2", "new1\nmiddle\nnew2")
+	if small.Added() != 2 || small.Removed() != 2 {
+		t.Errorf("small Compare = %v; want middle kept", small)
+	}
+}
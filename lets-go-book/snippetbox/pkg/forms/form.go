@@ -0,0 +1,192 @@
+package forms
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/i18n"
+)
+
+// Errors holds the validation failure messages for a form, keyed by field
+// name. A field can fail more than one check, so each has a list.
+type Errors map[string][]string
+
+// Add adds a failure message for the given field.
+func (e Errors) Add(field, message string) {
+	e[field] = append(e[field], message)
+}
+
+// Get returns the first failure message for the field, or "" if it has none.
+func (e Errors) Get(field string) string {
+	if msgs := e[field]; len(msgs) > 0 {
+		return msgs[0]
+	}
+	return ""
+}
+
+// Has reports whether the field has failed any check.
+func (e Errors) Has(field string) bool {
+	return len(e[field]) > 0
+}
+
+// Form wraps submitted form data (usually r.PostForm) with the checks to run
+// on it. The checks can be chained:
+//
+//	form := forms.New(r.PostForm, printer)
+//	form.Required("title", "content").MaxLength("title", 100)
+//	if !form.Valid() { ... }
+//
+// Each check that fails adds a translated message to form.Errors, which the
+// templates show next to the field. Templates get the submitted values back
+// with {{.Get "title"}}.
+type Form struct {
+	url.Values
+	Errors  Errors
+	Printer *i18n.Printer // Translates the messages; nil for English.
+}
+
+// New returns a Form for data, which may be nil for a form that hasn't been
+// submitted yet.
+func New(data url.Values, printer *i18n.Printer) *Form {
+	if data == nil {
+		data = url.Values{}
+	}
+	return &Form{Values: data, Errors: Errors{}, Printer: printer}
+}
+
+// Required checks that the fields aren't blank.
+func (f *Form) Required(fields ...string) *Form {
+	for _, field := range fields {
+		if strings.TrimSpace(f.Get(field)) == "" {
+			f.Errors.Add(field, f.Printer.T("This field cannot be blank"))
+		}
+	}
+	return f
+}
+
+// MaxLength checks that the field is at most n characters long.
+func (f *Form) MaxLength(field string, n int) *Form {
+	if utf8.RuneCountInString(f.Get(field)) > n {
+		f.Errors.Add(field, f.Printer.T("This field cannot be longer than %d characters", n))
+	}
+	return f
+}
+
+// MinLength checks that the field, if it has been filled in, is at least n
+// characters long. (Use Required to check that it has.)
+func (f *Form) MinLength(field string, n int) *Form {
+	value := f.Get(field)
+	if value != "" && utf8.RuneCountInString(value) < n {
+		f.Errors.Add(field, f.Printer.T("This field cannot be shorter than %d characters", n))
+	}
+	return f
+}
+
+// PermittedValues checks that the field, if it has been filled in, is one of
+// the options.
+func (f *Form) PermittedValues(field string, options ...string) *Form {
+	value := f.Get(field)
+	if value == "" {
+		return f
+	}
+	for _, opt := range options {
+		if value == opt {
+			return f
+		}
+	}
+	f.Errors.Add(field, f.Printer.T("This field is invalid"))
+	return f
+}
+
+// MatchesPattern checks that the field, if it has been filled in, matches
+// the regular expression.
+func (f *Form) MatchesPattern(field string, pattern *regexp.Regexp) *Form {
+	value := f.Get(field)
+	if value != "" && !pattern.MatchString(value) {
+		f.Errors.Add(field, f.Printer.T("This field is invalid"))
+	}
+	return f
+}
+
+var rxEmail = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// Email checks that the field, if it has been filled in, looks like an email
+// address.
+func (f *Form) Email(field string) *Form {
+	value := f.Get(field)
+	if value != "" && (len(value) > 254 || !rxEmail.MatchString(value)) {
+		f.Errors.Add(field, f.Printer.T("This is not a valid email address"))
+	}
+	return f
+}
+
+// Check adds message as a failure for the field unless ok is true. It's for
+// the one-off checks that don't deserve a method of their own.
+func (f *Form) Check(ok bool, field, message string) *Form {
+	if !ok {
+		f.Errors.Add(field, message)
+	}
+	return f
+}
+
+// Valid reports whether every check so far has passed.
+func (f *Form) Valid() bool {
+	return len(f.Errors) == 0
+}
+
+// Decode copies the form values into the fields of the struct dst points to,
+// using the field's form tag as the name to look up:
+//
+//	type NewSnippet struct {
+//		Title string `form:"title"`
+//	}
+//
+// Untagged fields are left alone. Fields can be strings, ints, bools (which
+// are true for "on", as sent by a ticked checkbox, or any true value
+// strconv.ParseBool accepts) or string slices.
+func (f *Form) Decode(dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("forms: Decode needs a pointer to a struct, not %T", dst)
+	}
+	v = v.Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("form")
+		if name == "" || name == "-" {
+			continue
+		}
+		value := f.Get(name)
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if value == "" {
+				field.SetInt(0)
+				continue
+			}
+			n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("forms: %s: %w", name, err)
+			}
+			field.SetInt(n)
+		case reflect.Bool:
+			b, _ := strconv.ParseBool(value)
+			field.SetBool(b || value == "on")
+		case reflect.Slice:
+			if field.Type().Elem().Kind() != reflect.String {
+				return fmt.Errorf("forms: %s: can't decode into %s", name, field.Type())
+			}
+			field.Set(reflect.ValueOf(append([]string(nil), f.Values[name]...)))
+		default:
+			return fmt.Errorf("forms: %s: can't decode into %s", name, field.Type())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package forms
+
+import (
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	var dst struct {
+		Title    string   `form:"title"`
+		Count    int      `form:"count"`
+		Small    int8     `form:"small"`
+		Missing  int      `form:"missing"`
+		Public   bool     `form:"public"`
+		Checkbox bool     `form:"checkbox"`
+		Off      bool     `form:"off"`
+		Tags     []string `form:"tag"`
+		Skipped  string   `form:"-"`
+		Untagged string
+	}
+	dst.Missing = 7
+	dst.Skipped, dst.Untagged = "kept", "kept"
+
+	f := New(url.Values{
+		"title":    {"Hello"},
+		"count":    {"42"},
+		"small":    {"-5"},
+		"public":   {"true"},
+		"checkbox": {"on"},
+		"off":      {"nonsense"},
+		"tag":      {"go", "web"},
+		"Untagged": {"changed"},
+		"-":        {"changed"},
+	}, nil)
+	if err := f.Decode(&dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Title != "Hello" || dst.Count != 42 || dst.Small != -5 || dst.Missing != 0 {
+		t.Errorf("strings and ints decoded as %q, %d, %d, %d", dst.Title, dst.Count, dst.Small, dst.Missing)
+	}
+	if !dst.Public || !dst.Checkbox || dst.Off {
+		t.Errorf("bools decoded as %v, %v, %v; want true, true, false", dst.Public, dst.Checkbox, dst.Off)
+	}
+	if !reflect.DeepEqual(dst.Tags, []string{"go", "web"}) {
+		t.Errorf("Tags = %q; want [go web]", dst.Tags)
+	}
+	if dst.Skipped != "kept" || dst.Untagged != "kept" {
+		t.Errorf("untagged fields were changed to %q and %q", dst.Skipped, dst.Untagged)
+	}
+
+	// The decoded slice mustn't share the form's backing array.
+	dst.Tags[0] = "changed"
+	if f.Values["tag"][0] != "go" {
+		t.Error("changing the decoded slice changed the form")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		dst  any
+		data url.Values
+		want string
+	}{
+		{"bad int", &struct {
+			N int `form:"n"`
+		}{}, url.Values{"n": {"seven"}}, "forms: n:"},
+		{"int out of range", &struct {
+			N int8 `form:"n"`
+		}{}, url.Values{"n": {"300"}}, "forms: n:"},
+		{"unsupported kind", &struct {
+			F float64 `form:"f"`
+		}{}, url.Values{"f": {"1.5"}}, "can't decode into float64"},
+		{"unsupported slice", &struct {
+			Ns []int `form:"n"`
+		}{}, url.Values{"n": {"1"}}, "can't decode into []int"},
+		{"not a pointer", struct{}{}, nil, "needs a pointer to a struct"},
+		{"pointer to non-struct", new(string), nil, "needs a pointer to a struct"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := New(tt.data, nil).Decode(tt.dst)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Decode error = %v; want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidators(t *testing.T) {
+	data := url.Values{
+		"blank":  {"   "},
+		"title":  {"héllo"},
+		"format": {"code"},
+		"email":  {"alice@example.com"},
+		"bad":    {"not an email"},
+		"slug":   {"a-b"},
+	}
+	tests := []struct {
+		name  string
+		check func(*Form) *Form
+		field string // The field that should fail, or "" if none should.
+	}{
+		{"required", func(f *Form) *Form { return f.Required("title", "format") }, ""},
+		{"required blank", func(f *Form) *Form { return f.Required("title", "blank") }, "blank"},
+		{"required missing", func(f *Form) *Form { return f.Required("nothing") }, "nothing"},
+		// "héllo" is 5 characters but 6 bytes.
+		{"max length in characters", func(f *Form) *Form { return f.MaxLength("title", 5) }, ""},
+		{"max length", func(f *Form) *Form { return f.MaxLength("title", 4) }, "title"},
+		{"min length", func(f *Form) *Form { return f.MinLength("title", 5) }, ""},
+		{"min length short", func(f *Form) *Form { return f.MinLength("title", 6) }, "title"},
+		{"min length empty", func(f *Form) *Form { return f.MinLength("nothing", 6) }, ""},
+		{"permitted", func(f *Form) *Form { return f.PermittedValues("format", "plain", "code") }, ""},
+		{"not permitted", func(f *Form) *Form { return f.PermittedValues("format", "plain") }, "format"},
+		{"permitted empty", func(f *Form) *Form { return f.PermittedValues("nothing", "plain") }, ""},
+		{"pattern", func(f *Form) *Form { return f.MatchesPattern("slug", regexp.MustCompile(`^[a-z-]+$`)) }, ""},
+		{"pattern mismatch", func(f *Form) *Form { return f.MatchesPattern("title", regexp.MustCompile(`^[a-z]+$`)) }, "title"},
+		{"email", func(f *Form) *Form { return f.Email("email") }, ""},
+		{"bad email", func(f *Form) *Form { return f.Email("bad") }, "bad"},
+		{"long email", func(f *Form) *Form {
+			f.Set("long", strings.Repeat("a", 250)+"@example.com")
+			return f.Email("long")
+		}, "long"},
+		{"check", func(f *Form) *Form { return f.Check(true, "title", "nope") }, ""},
+		{"check fails", func(f *Form) *Form { return f.Check(false, "title", "nope") }, "title"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := url.Values{}
+			for k, v := range data {
+				values[k] = v
+			}
+			f := tt.check(New(values, nil))
+			if tt.field == "" {
+				if !f.Valid() {
+					t.Errorf("errors = %v; want none", f.Errors)
+				}
+				return
+			}
+			if f.Valid() || !f.Errors.Has(tt.field) || len(f.Errors) != 1 {
+				t.Errorf("errors = %v; want one for %q", f.Errors, tt.field)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	f := New(nil, nil)
+	f.Required("title").Check(false, "title", "second")
+	if got := f.Errors.Get("title"); got != "This field cannot be blank" {
+		t.Errorf("Errors.Get = %q; want the first message", got)
+	}
+	if len(f.Errors["title"]) != 2 {
+		t.Errorf("title has %d messages; want 2", len(f.Errors["title"]))
+	}
+	if f.Errors.Get("other") != "" || f.Errors.Has("other") {
+		t.Error("a field with no failures has a message")
+	}
+}
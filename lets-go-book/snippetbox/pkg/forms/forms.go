@@ -1,87 +1,217 @@
 package forms
 
 import (
-	"regexp"
+	"fmt"
+	"mime/multipart"
 	"strings"
-	"unicode/utf8"
+	"time"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
 )
 
-var rxEmail = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+// The structs below are what the forms decode into (see Form.Decode). Each
+// has a Valid method which runs its checks on the submitted Form.
 
-// Declare a struct to hold the form values
-// (and also a map to hold any validation failure messages).
 type NewSnippet struct {
-	Title    string
-	Content  string
-	Expires  string
-	Failures map[string]string
+	Title   string `form:"title"`
+	Content string `form:"content"`
+	Format  string `form:"format"`
+	Expires string `form:"expires"`
+	Tags    string `form:"tags"`      // Comma separated.
+	Parent  int    `form:"parent_id"` // For a fork, the snippet it was forked from.
+	// E2E is set by the browser once it has encrypted Content itself; see
+	// ui/static/js/e2e.js.
+	E2E bool `form:"e2e"`
+
+	// The files come as repeated fields, one of each per file.
+	FileNames     []string `form:"file_name"`
+	FileLanguages []string `form:"file_language"`
+	FileContents  []string `form:"file_content"`
+
+	// Uploads are the files to attach, from the multipart form; the handler
+	// sets them. Policy is DefaultUploadPolicy if nil.
+	Uploads []*multipart.FileHeader
+	Policy  *UploadPolicy
 }
 
-// Implement a Valid() method which carries out validation checks on the form
-// fields and returns true if there are no failures.
-func (f *NewSnippet) Valid() bool {
-	f.Failures = make(map[string]string)
-	// Check that the Title field is not blank and is not more than 100 characters
-	// long. If it fails either of those checks, add a message to the f.Failures
-	// map using the field name as the key.
-	if strings.TrimSpace(f.Title) == "" {
-		f.Failures["Title"] = "Title is required"
-	} else if utf8.RuneCountInString(f.Title) > 100 {
-		f.Failures["Title"] = "Title cannot be longer than 100 characters"
+// Valid checks that the Title and Content fields are filled in, that the title
+// isn't more than 100 characters long, that Format and Expires are each one of
+// a fixed list and that the tags are ones we can store.
+func (s *NewSnippet) Valid(f *Form) bool {
+	f.Required("title", "content", "format", "expires").
+		MaxLength("title", 100).
+		PermittedValues("format", string(models.FormatPlain), string(models.FormatCode), string(models.FormatMarkdown)).
+		PermittedValues("expires", "3600", "86400", "31536000")
+
+	tags := s.TagList()
+	f.Check(len(tags) <= models.MaxTags, "tags", f.Printer.T("A snippet can have at most %d tags", models.MaxTags))
+	for _, tag := range tags {
+		f.Check(models.ValidTag(tag), "tags",
+			f.Printer.T("%q is not a valid tag: use up to %d letters, digits, dots, hyphens or underscores",
+				tag, models.MaxTagLength))
 	}
-	// Validate the Content and Expires fields aren't blank in a similar way.
-	if strings.TrimSpace(f.Content) == "" {
-		f.Failures["Content"] = "Content is required"
+
+	// The server can't read an end-to-end encrypted snippet, so it can't
+	// render it as Markdown, and it mustn't have anything (files,
+	// attachments) that the server could read alongside it.
+	if s.E2E {
+		f.Check(f.Errors.Has("content") || models.ValidCiphertext(s.Content), "content",
+			f.Printer.T("The content wasn't encrypted properly; please try again"))
+		f.Check(s.Format != string(models.FormatMarkdown), "format",
+			f.Printer.T("An end-to-end encrypted snippet can't be shown as Markdown"))
+		f.Check(len(s.Files()) == 0, "files",
+			f.Printer.T("An end-to-end encrypted snippet can't have files"))
 	}
-	// Check that the Expires field isn't blank and is one of a fixed list. Using
-	// a lookup on a map keyed with the permitted options and values of true is a
-	// neat trick which saves you looping over the permitted values.
-	permitted := map[string]bool{"3600": true, "86400": true, "31536000": true}
-	if strings.TrimSpace(f.Expires) == "" {
-		f.Failures["Expires"] = "Expiry time is required"
-	} else if !permitted[f.Expires] {
-		f.Failures["Expires"] = "Expiry time must be 3600, 86400 or 31536000 seconds"
+
+	// Failures for a file are keyed by its place in Files, as "file.0".
+	files := s.Files()
+	f.Check(len(files) <= models.MaxFiles, "files", f.Printer.T("A snippet can have at most %d files", models.MaxFiles))
+	seen := map[string]bool{}
+	for i, file := range files {
+		key := fmt.Sprintf("file.%d", i)
+		if !models.ValidFileName(file.Name) {
+			f.Errors.Add(key, f.Printer.T("Name the file with letters, digits, dots, hyphens or underscores"))
+		} else if seen[file.Name] {
+			f.Errors.Add(key, f.Printer.T("There is already a file called %q", file.Name))
+		}
+		seen[file.Name] = true
+		f.Check(models.ValidLanguage(file.Language), key, f.Printer.T("This field is invalid"))
+		f.Check(strings.TrimSpace(file.Content) != "", key, f.Printer.T("The file is empty"))
 	}
-	// If there are no failure messages, return true.
-	return len(f.Failures) == 0
+	return f.Valid()
 }
 
-type SignupUser struct {
-	Name     string
-	Email    string
-	Password string
-	Failures map[string]string
+// CheckUploads checks the files to attach against the policy, adding any
+// failures to the form under "attachments". Call it before Valid. The error is
+// for uploads that couldn't be read.
+func (s *NewSnippet) CheckUploads(f *Form) error {
+	policy := s.Policy
+	if policy == nil {
+		policy = DefaultUploadPolicy
+	}
+	f.Check(!s.E2E || len(s.Uploads) == 0, "attachments",
+		f.Printer.T("An end-to-end encrypted snippet can't have attachments"))
+	f.Check(len(s.Uploads) <= models.MaxAttachments, "attachments",
+		f.Printer.T("A snippet can have at most %d attachments", models.MaxAttachments))
+	for _, fh := range s.Uploads {
+		msg, err := policy.Check(f.Printer, fh)
+		if err != nil {
+			return err
+		}
+		f.Check(msg == "", "attachments", msg)
+	}
+	return nil
+}
+
+// Files returns the files that were filled in, in order. A file left with
+// neither a name nor any content is dropped, which is how one is removed.
+func (s *NewSnippet) Files() []models.File {
+	var files []models.File
+	for i, name := range s.FileNames {
+		file := models.File{Name: strings.TrimSpace(name), Language: "text"}
+		if i < len(s.FileLanguages) && s.FileLanguages[i] != "" {
+			file.Language = s.FileLanguages[i]
+		}
+		if i < len(s.FileContents) {
+			file.Content = s.FileContents[i]
+		}
+		if file.Name == "" && strings.TrimSpace(file.Content) == "" {
+			continue
+		}
+		files = append(files, file)
+	}
+	return files
 }
 
-func (f *SignupUser) Valid() bool {
-	f.Failures = make(map[string]string)
-	if strings.TrimSpace(f.Name) == "" {
-		f.Failures["Name"] = "Name is required"
+// TagList returns the normalized tags, without duplicates.
+func (s *NewSnippet) TagList() []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, tag := range strings.Split(s.Tags, ",") {
+		tag = models.NormalizeTag(tag)
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
 	}
-	if strings.TrimSpace(f.Email) == "" {
-		f.Failures["Email"] = "Email is required"
-	} else if len(f.Email) > 254 || !rxEmail.MatchString(f.Email) {
-		f.Failures["Email"] = "Email is not a valid address"
+	return tags
+}
+
+// Collection is the form for creating a collection and for renaming it or
+// changing who can see it.
+type Collection struct {
+	Name       string `form:"name"`
+	Visibility string `form:"visibility"`
+}
+
+func (c *Collection) Valid(f *Form) bool {
+	f.Required("name", "visibility").
+		MaxLength("name", 100).
+		PermittedValues("visibility", string(models.Public), string(models.Unlisted), string(models.Private))
+	return f.Valid()
+}
+
+// Comment is the form for commenting on a snippet, replying to a comment, or
+// editing one (when only the body is sent).
+type Comment struct {
+	Body   string `form:"body"`
+	Line   int    `form:"line"`      // The line it's about, or 0 for the whole snippet.
+	Parent int    `form:"parent_id"` // The comment it replies to, or 0.
+	Lines  int    // How many lines the snippet has; set by the handler.
+}
+
+func (c *Comment) Valid(f *Form) bool {
+	f.Required("body").
+		MaxLength("body", models.MaxCommentLength)
+	if c.Line != 0 {
+		f.Check(c.Line > 0 && c.Line <= c.Lines, "line", f.Printer.T("The snippet has no line %d", c.Line))
 	}
-	if utf8.RuneCountInString(f.Password) < 8 {
-		f.Failures["Password"] = "Password cannot be shorter than 8 characters"
+	return f.Valid()
+}
+
+type SignupUser struct {
+	Name     string          `form:"name"`
+	Email    string          `form:"email"`
+	Password string          `form:"password"`
+	Policy   *PasswordPolicy // DefaultPasswordPolicy if nil.
+}
+
+func (u *SignupUser) Valid(f *Form) bool {
+	f.Required("name", "email", "password").
+		MaxLength("name", 255).
+		Email("email")
+	if !f.Errors.Has("password") {
+		policy := u.Policy
+		if policy == nil {
+			policy = DefaultPasswordPolicy
+		}
+		msg := policy.Check(f.Printer, u.Password, u.Name, u.Email)
+		f.Check(msg == "", "password", msg)
 	}
-	return len(f.Failures) == 0
+	return f.Valid()
 }
 
 type LoginUser struct {
-	Email    string
-	Password string
-	Failures map[string]string
+	Email    string `form:"email"`
+	Password string `form:"password"`
 }
 
-func (f *LoginUser) Valid() bool {
-	f.Failures = make(map[string]string)
-	if strings.TrimSpace(f.Email) == "" {
-		f.Failures["Email"] = "Email is required"
-	}
-	if strings.TrimSpace(f.Password) == "" {
-		f.Failures["Password"] = "Password is required"
+func (u *LoginUser) Valid(f *Form) bool {
+	f.Required("email", "password")
+	return f.Valid()
+}
+
+type Settings struct {
+	TimeZone string `form:"time_zone"` // Empty to go by the browser.
+}
+
+func (s *Settings) Valid(f *Form) bool {
+	s.TimeZone = strings.TrimSpace(s.TimeZone)
+	if s.TimeZone != "" {
+		// LoadLocation takes "Local" to mean the server's zone, which isn't
+		// what anyone typing it here would want.
+		_, err := time.LoadLocation(s.TimeZone)
+		f.Check(err == nil && s.TimeZone != "Local", "time_zone", f.Printer.T("Unknown time zone"))
 	}
-	return len(f.Failures) == 0
+	return f.Valid()
 }
@@ -0,0 +1,67 @@
+package forms
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/bloom"
+	"github.com/noelruault/lets-go/snippetbox/pkg/i18n"
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
+)
+
+// A PasswordPolicy describes what a new password must look like. The zero
+// value only enforces DefaultPasswordPolicy's lengths.
+type PasswordPolicy struct {
+	MinLength int // In characters.
+	MaxLength int // In characters.
+	// MaxBytes, if set, also limits the password's length in bytes, which is
+	// what bcrypt counts: it won't hash a password longer than
+	// models.BcryptMaxBytes. A character can take up to 4 bytes.
+	MaxBytes int
+	// Breached, if set, holds passwords known from public breaches. Any
+	// password it (probably) contains is refused.
+	Breached *bloom.Filter
+}
+
+// DefaultPasswordPolicy is used by forms that haven't been given a policy. It
+// suits the default bcrypt hasher, which takes at most 72 bytes.
+var DefaultPasswordPolicy = &PasswordPolicy{MinLength: 8, MaxLength: 72, MaxBytes: models.BcryptMaxBytes}
+
+// Check returns a failure message, translated by printer, if password breaks
+// the policy, or an empty string if it's acceptable. The user's name and email
+// are passed in so that passwords built from them can be refused.
+func (p *PasswordPolicy) Check(printer *i18n.Printer, password, name, email string) string {
+	min, max := p.MinLength, p.MaxLength
+	if min == 0 {
+		min = DefaultPasswordPolicy.MinLength
+	}
+	if max == 0 {
+		max = DefaultPasswordPolicy.MaxLength
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < min {
+		return printer.T("Password cannot be shorter than %d characters", min)
+	} else if length > max {
+		return printer.T("Password cannot be longer than %d characters", max)
+	} else if p.MaxBytes != 0 && len(password) > p.MaxBytes {
+		return printer.T("Password is too long: accented letters and symbols count as more than one character, up to %d in all", p.MaxBytes)
+	}
+
+	lower := strings.ToLower(password)
+	local := strings.ToLower(email)
+	if i := strings.LastIndex(local, "@"); i >= 0 {
+		local = local[:i]
+	}
+	for _, s := range []string{strings.ToLower(strings.TrimSpace(name)), local} {
+		// Very short names would rule out too many good passwords.
+		if utf8.RuneCountInString(s) >= 3 && strings.Contains(lower, s) {
+			return printer.T("Password cannot contain your name or email address")
+		}
+	}
+
+	if p.Breached != nil && p.Breached.Test(password) {
+		return printer.T("Password has appeared in a data breach, please choose another")
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package forms
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/bloom"
+	"github.com/noelruault/lets-go/snippetbox/pkg/models"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	breached := bloom.New(10, 0.001)
+	breached.Add("password123")
+
+	custom := &PasswordPolicy{MinLength: 4, MaxLength: 10, Breached: breached}
+	tests := []struct {
+		name     string
+		policy   *PasswordPolicy
+		password string
+		ok       bool
+	}{
+		{"acceptable", DefaultPasswordPolicy, "correct horse", true},
+		{"too short", DefaultPasswordPolicy, "short", false},
+		{"72 ASCII bytes", DefaultPasswordPolicy, strings.Repeat("a", 72), true},
+		{"73 characters", DefaultPasswordPolicy, strings.Repeat("a", 73), false},
+		// 40 characters, but 80 bytes: more than bcrypt takes.
+		{"multibyte over 72 bytes", DefaultPasswordPolicy, strings.Repeat("é", 40), false},
+		{"multibyte within 72 bytes", DefaultPasswordPolicy, strings.Repeat("é", 36), true},
+		{"no byte limit", &PasswordPolicy{MaxLength: 100}, strings.Repeat("é", 40), true},
+		{"zero value uses default lengths", &PasswordPolicy{}, "seven77", false},
+		{"custom minimum", custom, "abcd", true},
+		{"custom maximum", custom, "abcdefghijk", false},
+		{"contains name", DefaultPasswordPolicy, "my Alice Smith!", false},
+		{"contains email", DefaultPasswordPolicy, "alice.s-2024!", false},
+		{"breached", &PasswordPolicy{Breached: breached}, "password123", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.policy.Check(nil, tt.password, "Alice Smith", "alice.s@example.com")
+			if ok := msg == ""; ok != tt.ok {
+				t.Errorf("Check(%q) = %q; want ok = %v", tt.password, msg, tt.ok)
+			}
+		})
+	}
+}
+
+// Whatever the default policy accepts, the default (bcrypt) hasher must be
+// able to hash.
+func TestDefaultPolicyFitsBcrypt(t *testing.T) {
+	for _, pw := range []string{strings.Repeat("a", 72), strings.Repeat("é", 36), strings.Repeat("€", 24)} {
+		if msg := DefaultPasswordPolicy.Check(nil, pw, "", ""); msg != "" {
+			t.Fatalf("Check(%q) = %q; want it accepted", pw, msg)
+		}
+		h := &models.PasswordHasher{BcryptCost: 4}
+		if _, err := h.Hash(pw); err != nil {
+			t.Errorf("Hash(%q): %v", pw, err)
+		}
+	}
+}
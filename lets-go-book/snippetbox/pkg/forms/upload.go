@@ -0,0 +1,73 @@
+package forms
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/i18n"
+)
+
+// An UploadPolicy says which files can be attached to a snippet. The zero
+// value uses DefaultUploadPolicy's limits.
+type UploadPolicy struct {
+	MaxSize int64 // In bytes.
+	// Types are the media types allowed, such as "image/png". A file's type
+	// is sniffed from its contents (see UploadType), never taken from the
+	// browser.
+	Types []string
+}
+
+// DefaultUploadPolicy allows images, PDFs, plain text and zip archives up to
+// 10 MB. SVG images aren't allowed: they can carry scripts.
+var DefaultUploadPolicy = &UploadPolicy{
+	MaxSize: 10 << 20,
+	Types: []string{
+		"image/png", "image/jpeg", "image/gif", "image/webp",
+		"application/pdf", "text/plain", "application/zip",
+	},
+}
+
+// UploadType sniffs the media type of an uploaded file from its first 512
+// bytes, as http.DetectContentType does, e.g. "text/plain; charset=utf-8".
+func UploadType(fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// Check returns a failure message, translated by printer, if the uploaded
+// file breaks the policy, or an empty string if it's acceptable.
+func (p *UploadPolicy) Check(printer *i18n.Printer, fh *multipart.FileHeader) (string, error) {
+	maxSize, types := p.MaxSize, p.Types
+	if maxSize == 0 {
+		maxSize = DefaultUploadPolicy.MaxSize
+	}
+	if types == nil {
+		types = DefaultUploadPolicy.Types
+	}
+
+	if fh.Size > maxSize {
+		return printer.T("%s is bigger than the %d MB limit", fh.Filename, maxSize>>20), nil
+	}
+	contentType, err := UploadType(fh)
+	if err != nil {
+		return "", err
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, t := range types {
+		if t == mediaType {
+			return "", nil
+		}
+	}
+	return printer.T("%s is a kind of file that can't be attached (%s)", fh.Filename, mediaType), nil
+}
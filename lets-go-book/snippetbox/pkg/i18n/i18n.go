@@ -0,0 +1,226 @@
+// Package i18n translates the user interface. Messages are keyed by their
+// English text, so English needs no translations of its own and anything
+// missing from a catalog falls back to English rather than to a bare key.
+//
+// Each language is a JSON file in the locales directory, named for its
+// language tag:
+//
+//	{
+//		"name": "Español",
+//		"date": "{day} {month} {year} a las {time}",
+//		"months": ["ene", "feb", ...],
+//		"messages": {"Home": "Inicio", "Snippet #%d": "Fragmento n.º %d", ...}
+//	}
+//
+// Messages with arguments are fmt format strings; use explicit argument
+// indexes (%[2]s) where a translation needs them in a different order.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+//go:embed locales/*.json
+var locales embed.FS
+
+// A Language is one of the languages the interface is available in.
+type Language struct {
+	Code string // The language tag, e.g. "es".
+	Name string // The name of the language, in that language.
+}
+
+type locale struct {
+	Name     string            `json:"name"`
+	Date     string            `json:"date"`
+	Months   []string          `json:"months"`
+	Messages map[string]string `json:"messages"`
+}
+
+// A Catalog holds the translations for every supported language.
+type Catalog struct {
+	languages []Language
+	locales   map[string]*locale
+	matcher   language.Matcher
+	tags      []language.Tag
+}
+
+// English is the source language, used when nothing better matches.
+const English = "en"
+
+// New loads the catalogs built into the package.
+func New() (*Catalog, error) {
+	return Load(locales, "locales")
+}
+
+// Load reads the *.json catalogs in dir. There must be one for English, if
+// only to give its name and date format.
+func Load(fsys fs.FS, dir string) (*Catalog, error) {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	c := &Catalog{locales: map[string]*locale{}}
+	// English goes first, as the matcher falls back to the first tag.
+	c.tags = append(c.tags, language.English)
+	for _, file := range files {
+		code := strings.TrimSuffix(path.Base(file), ".json")
+		tag, err := language.Parse(code)
+		if err != nil {
+			return nil, fmt.Errorf("i18n: %s: %w", file, err)
+		}
+		b, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		l := &locale{}
+		if err := json.Unmarshal(b, l); err != nil {
+			return nil, fmt.Errorf("i18n: %s: %w", file, err)
+		}
+		if len(l.Months) != 12 || l.Date == "" {
+			return nil, fmt.Errorf("i18n: %s: needs a date format and 12 months", file)
+		}
+		c.locales[tag.String()] = l
+		if tag != language.English {
+			c.tags = append(c.tags, tag)
+		}
+	}
+	if c.locales[English] == nil {
+		return nil, fmt.Errorf("i18n: no catalog for %s", English)
+	}
+	for _, tag := range c.tags {
+		c.languages = append(c.languages, Language{Code: tag.String(), Name: c.locales[tag.String()].Name})
+	}
+	c.matcher = language.NewMatcher(c.tags)
+	return c, nil
+}
+
+// Languages lists the supported languages, English first.
+func (c *Catalog) Languages() []Language {
+	return c.languages
+}
+
+// Supported reports whether code is one of the supported language tags.
+func (c *Catalog) Supported(code string) bool {
+	_, ok := c.locales[code]
+	return ok
+}
+
+// Printer returns a Printer for the best match among the preferences, which
+// are tried in order. Each can be a single language tag (a saved preference)
+// or a whole Accept-Language header. Empty ones are skipped, and if none of
+// them match, the Printer is for English.
+func (c *Catalog) Printer(prefs ...string) *Printer {
+	for _, pref := range prefs {
+		if pref == "" {
+			continue
+		}
+		tags, _, err := language.ParseAcceptLanguage(pref)
+		if err != nil || len(tags) == 0 {
+			continue
+		}
+		_, i, confidence := c.matcher.Match(tags...)
+		if confidence != language.No {
+			code := c.tags[i].String()
+			return &Printer{lang: code, locale: c.locales[code]}
+		}
+	}
+	return &Printer{lang: English, locale: c.locales[English]}
+}
+
+// A Printer translates messages into one language. A nil *Printer prints
+// English.
+type Printer struct {
+	lang   string
+	locale *locale
+}
+
+// Lang returns the Printer's language tag.
+func (p *Printer) Lang() string {
+	if p == nil {
+		return English
+	}
+	return p.lang
+}
+
+// T translates the message key and, if there are any args, formats them
+// into it like fmt.Sprintf.
+func (p *Printer) T(key string, args ...any) string {
+	msg := key
+	if p != nil && p.locale != nil {
+		if s, ok := p.locale.Messages[key]; ok && s != "" {
+			msg = s
+		}
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+var englishMonths = []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+
+// Date formats t the way humanDate always has ("02 Jan 2006 at 15:04"), in
+// the Printer's language.
+func (p *Printer) Date(t time.Time) string {
+	layout, months := "{day} {month} {year} at {time}", englishMonths
+	if p != nil && p.locale != nil {
+		layout, months = p.locale.Date, p.locale.Months
+	}
+	return strings.NewReplacer(
+		"{day}", t.Format("02"),
+		"{month}", months[t.Month()-1],
+		"{year}", t.Format("2006"),
+		"{time}", t.Format("15:04"),
+	).Replace(layout)
+}
+
+// Relative describes t relative to now, like "in 3 hours" or "2 days ago".
+// It's deliberately rough: only the largest unit counts, and anything within
+// a minute is "just now".
+func (p *Printer) Relative(t, now time.Time) string {
+	d := t.Sub(now)
+	future := d > 0
+	if !future {
+		d = -d
+	}
+	n, unit := 0, ""
+	switch {
+	case d < time.Minute:
+		return p.T("just now")
+	case d < time.Hour:
+		n, unit = int(d/time.Minute), "minute"
+	case d < 24*time.Hour:
+		n, unit = int(d/time.Hour), "hour"
+	case d < 30*24*time.Hour:
+		n, unit = int(d/(24*time.Hour)), "day"
+	case d < 365*24*time.Hour:
+		n, unit = int(d/(30*24*time.Hour)), "month"
+	default:
+		n, unit = int(d/(365*24*time.Hour)), "year"
+	}
+	// The catalogs have a message for each unit, in the singular ("in 1
+	// hour") and the plural ("in %d hours").
+	var key string
+	if n == 1 {
+		key = "1 " + unit
+	} else {
+		key = "%d " + unit + "s"
+	}
+	if future {
+		key = "in " + key
+	} else {
+		key += " ago"
+	}
+	if n == 1 {
+		return p.T(key)
+	}
+	return p.T(key, n)
+}
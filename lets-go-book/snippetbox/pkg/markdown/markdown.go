@@ -0,0 +1,48 @@
+// Package markdown renders snippets written in Markdown to HTML that is safe
+// to put in a page.
+package markdown
+
+import (
+	"bytes"
+	"html/template"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// GitHub flavoured Markdown, since that's what people write. goldmark leaves
+// out raw HTML in the source unless told otherwise, but we sanitize what it
+// produces anyway rather than rely on that.
+var md = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// policy is what the sanitizer lets through: the formatting Markdown can
+// produce, and links and images to http(s) and mailto URLs only (no
+// javascript: or data:). Links get rel="nofollow noreferrer", and ones to
+// other sites open in a new tab.
+var policy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.RequireNoReferrerOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	// The language of fenced code blocks, and task list checkboxes.
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#.-]+$`)).OnElements("code")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^(|checked|disabled)$`)).OnElements("input")
+	return p
+}()
+
+// Render converts Markdown to sanitized HTML. This is the only place snippet
+// content becomes template.HTML, so the templates never see HTML that hasn't
+// been through the sanitizer.
+func Render(src string) (template.HTML, error) {
+	var buf bytes.Buffer
+	err := md.Convert([]byte(src), &buf)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(policy.SanitizeBytes(buf.Bytes())), nil
+}
@@ -0,0 +1,102 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+// unsafe are the things that mustn't survive rendering, in lower case.
+var unsafe = []string{"<script", "javascript:", "data:", "onerror", "onclick", "onmouseover", "<iframe", "<style"}
+
+func checkSafe(t *testing.T, src, html string) {
+	t.Helper()
+	lower := strings.ToLower(html)
+	for _, bad := range unsafe {
+		if strings.Contains(lower, bad) {
+			t.Errorf("%q rendered as %q, which contains %q", src, html, bad)
+		}
+	}
+}
+
+func TestRenderStripsUnsafe(t *testing.T) {
+	for _, src := range []string{
+		"<script>alert(1)</script>",
+		"[x](javascript:alert(1))",
+		"[x](JaVaScRiPt:alert(1))",
+		"[x](java\tscript:alert(1))",
+		"![x](data:text/html;base64,PHNjcmlwdD4=)",
+		"<img src=x onerror=alert(1)>",
+		`<a href="https://example.com" onclick="alert(1)">y</a>`,
+		`<p onmouseover="alert(1)">hi</p>`,
+		"<iframe src=https://example.com></iframe>",
+		"<style>body { display: none }</style>",
+	} {
+		html, err := Render(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSafe(t, src, string(html))
+	}
+}
+
+// goldmark already leaves raw HTML out, so check the sanitizer on its own
+// too: it mustn't depend on that.
+func TestPolicy(t *testing.T) {
+	for _, src := range []string{
+		`<script>alert(1)</script><p>ok</p>`,
+		`<a href="javascript:alert(1)">x</a>`,
+		`<a href="data:text/html,hi">x</a>`,
+		`<img src="data:image/png;base64,AAAA">`,
+		`<img src="https://example.com/x.png" onerror="alert(1)">`,
+		`<iframe src="https://example.com"></iframe>`,
+		`<div onclick="alert(1)">x</div>`,
+	} {
+		checkSafe(t, src, policy.Sanitize(src))
+	}
+
+	// What Markdown produces for code blocks and task lists is kept.
+	for _, src := range []string{
+		`<code class="language-go">x</code>`,
+		`<input checked="" disabled="" type="checkbox">`,
+	} {
+		if got := policy.Sanitize(src); got != src {
+			t.Errorf("Sanitize(%q) = %q; want it unchanged", src, got)
+		}
+	}
+	if got := policy.Sanitize(`<code class="evil">x</code>`); got != "<code>x</code>" {
+		t.Errorf("a code class that isn't a language survived: %q", got)
+	}
+}
+
+func TestRenderLinks(t *testing.T) {
+	html, err := Render("[site](https://example.com) [mail](mailto:a@example.com) [local](/snippet/1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(html)
+	for _, want := range []string{
+		`<a href="https://example.com" rel="nofollow noreferrer noopener" target="_blank">site</a>`,
+		`<a href="mailto:a@example.com" rel="nofollow noreferrer">mail</a>`,
+		`<a href="/snippet/1" rel="nofollow noreferrer">local</a>`,
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("Render = %q; want it to contain %q", s, want)
+		}
+	}
+}
+
+func TestRenderGFM(t *testing.T) {
+	html, err := Render("```go\nfmt.Println(\"<b>\")\n```\n\n- [x] done\n\n| a |\n|---|\n| 1 |")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<code class="language-go">fmt.Println(&#34;&lt;b&gt;&#34;)`,
+		`<input checked="" disabled="" type="checkbox"`,
+		`<table>`,
+	} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("Render = %q; want it to contain %q", html, want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"context"
+	"strings"
+)
+
+// Moderation actions recorded in the audit log.
+const (
+	ActionDisableUser    = "user.disable"
+	ActionEnableUser     = "user.enable"
+	ActionChangeRole     = "user.role"
+	ActionRemoveSnippet  = "snippet.remove"
+	ActionRestoreSnippet = "snippet.restore"
+	ActionRemoveComment  = "comment.remove"
+)
+
+// SearchUsers returns up to 50 users whose name or email contains query, most
+// recent signups first. An empty query lists the latest users.
+func (db *Database) SearchUsers(ctx context.Context, query string) (Users, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	// Escape the LIKE wildcards so a search for "a_b" means exactly that.
+	query = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query)
+	pattern := "%" + query + "%"
+
+	stmt := `SELECT id, name, email, role, disabled, created FROM users
+		WHERE name LIKE ? OR email LIKE ? ORDER BY created DESC LIMIT 50`
+	rows, err := db.QueryContext(ctx, stmt, pattern, pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := Users{}
+	for rows.Next() {
+		u := &User{}
+		err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.Disabled, &u.Created)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// SetUserDisabled disables or re-enables the account of userID on behalf of
+// actorID, recording the action in the audit log.
+func (db *Database) SetUserDisabled(ctx context.Context, actorID, userID int, disabled bool) error {
+	action := ActionEnableUser
+	if disabled {
+		action = ActionDisableUser
+	}
+	return db.moderate(ctx, actorID, action, "user", userID, "",
+		`UPDATE users SET disabled = ? WHERE id = ?`, disabled, userID)
+}
+
+// SetUserRole changes the role of userID on behalf of actorID, recording the
+// action in the audit log.
+func (db *Database) SetUserRole(ctx context.Context, actorID, userID int, role Role) error {
+	return db.moderate(ctx, actorID, ActionChangeRole, "user", userID, string(role),
+		`UPDATE users SET role = ? WHERE id = ?`, role, userID)
+}
+
+// RemoveSnippet hides a snippet from everybody without deleting it, so that
+// it can be brought back with RestoreSnippet.
+func (db *Database) RemoveSnippet(ctx context.Context, actorID, id int) error {
+	return db.moderate(ctx, actorID, ActionRemoveSnippet, "snippet", id, "",
+		`UPDATE snippets SET removed = UTC_TIMESTAMP() WHERE id = ? AND removed IS NULL`, id)
+}
+
+func (db *Database) RestoreSnippet(ctx context.Context, actorID, id int) error {
+	return db.moderate(ctx, actorID, ActionRestoreSnippet, "snippet", id, "",
+		`UPDATE snippets SET removed = NULL WHERE id = ? AND removed IS NOT NULL`, id)
+}
+
+// RemovedSnippets returns the 50 most recently removed snippets, whether or not
+// they have expired since.
+func (db *Database) RemovedSnippets(ctx context.Context) (Snippets, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	stmt := `SELECT id, title, created, expires FROM snippets
+		WHERE removed IS NOT NULL ORDER BY removed DESC LIMIT 50`
+	rows, err := db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snippets := Snippets{}
+	for rows.Next() {
+		s := &Snippet{}
+		err := rows.Scan(&s.ID, &s.Title, &s.Created, &s.Expires)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	return snippets, rows.Err()
+}
+
+// LatestAuditLog returns the 100 most recent moderation actions.
+func (db *Database) LatestAuditLog(ctx context.Context) (AuditLog, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	stmt := `SELECT a.id, a.actor_id, u.name, a.action, a.target_type, a.target_id,
+		a.detail, a.created
+		FROM audit_log a INNER JOIN users u ON u.id = a.actor_id
+		ORDER BY a.created DESC, a.id DESC LIMIT 100`
+	rows, err := db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	log := AuditLog{}
+	for rows.Next() {
+		e := &AuditEntry{}
+		err := rows.Scan(&e.ID, &e.ActorID, &e.ActorName, &e.Action, &e.TargetType,
+			&e.TargetID, &e.Detail, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		log = append(log, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// moderate runs a single-row update and writes the matching audit log entry
+// in the same transaction, so the log can never disagree with the data. If
+// the update touches no rows (unknown ID, or nothing to change) nothing is
+// logged and ErrNoRecord is returned.
+func (db *Database) moderate(ctx context.Context, actorID int, action, targetType string, targetID int,
+	detail, stmt string, args ...interface{}) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	err = insertAudit(ctx, tx, actorID, action, targetType, targetID, detail)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertAudit(ctx context.Context, tx *tx, actorID int, action, targetType string, targetID int,
+	detail string) error {
+	stmt := `INSERT INTO audit_log (actor_id, action, target_type, target_id, detail, created)
+		VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+	_, err := tx.ExecContext(ctx, stmt, actorID, action, targetType, targetID, detail)
+	return err
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxAttachments is how many files can be uploaded with a snippet.
+const MaxAttachments = 5
+
+// An Attachment is a file uploaded with a snippet. Its contents are in the
+// blob store, under Key.
+type Attachment struct {
+	ID          int
+	Name        string
+	ContentType string // As sniffed from the contents, not as the browser said.
+	Size        int64
+	Key         string
+}
+
+// AttachmentName makes an uploaded file's name safe to keep and to send back
+// in a Content-Disposition header: any directories are dropped, along with
+// control characters and leading dots, and it is cut to 255 bytes.
+func AttachmentName(filename string) string {
+	if i := strings.LastIndexAny(filename, `/\`); i >= 0 {
+		filename = filename[i+1:]
+	}
+	filename = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, filename)
+	filename = strings.TrimLeft(strings.TrimSpace(filename), ".")
+	for len(filename) > 255 {
+		_, size := utf8.DecodeLastRuneInString(filename)
+		filename = filename[:len(filename)-size]
+	}
+	if filename == "" {
+		return "attachment"
+	}
+	return filename
+}
+
+// insertAttachments records a new snippet's attachments inside its
+// transaction. Their blobs must already be stored.
+func insertAttachments(ctx context.Context, tx *tx, snippetID int, attachments []Attachment) error {
+	for _, a := range attachments {
+		_, err := tx.ExecContext(ctx, `INSERT INTO attachments (snippet_id, name, content_type, size, blob_key, created)
+			VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP())`, snippetID, a.Name, a.ContentType, a.Size, a.Key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadAttachments fills in the snippet's Attachments, in the order they were
+// uploaded.
+func (db *Database) loadAttachments(ctx context.Context, s *Snippet) error {
+	rows, err := db.QueryContext(ctx, `SELECT id, name, content_type, size, blob_key FROM attachments
+		WHERE snippet_id = ? ORDER BY id`, s.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.ID, &a.Name, &a.ContentType, &a.Size, &a.Key); err != nil {
+			return err
+		}
+		s.Attachments = append(s.Attachments, a)
+	}
+	return rows.Err()
+}
+
+// Attachment returns the snippet's attachment with the ID, or nil.
+func (s *Snippet) Attachment(id int) *Attachment {
+	for i := range s.Attachments {
+		if s.Attachments[i].ID == id {
+			return &s.Attachments[i]
+		}
+	}
+	return nil
+}
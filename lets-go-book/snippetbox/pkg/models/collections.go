@@ -0,0 +1,271 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Visibility says who can see a collection.
+type Visibility string
+
+const (
+	Public   Visibility = "public"   // Anyone, and it's listed.
+	Unlisted Visibility = "unlisted" // Anyone with the link.
+	Private  Visibility = "private"  // Only the owner.
+)
+
+// Valid reports whether v is one of the known visibilities.
+func (v Visibility) Valid() bool {
+	return v == Public || v == Unlisted || v == Private
+}
+
+// A Collection is a named, ordered group of snippets.
+type Collection struct {
+	ID           int
+	UserID       int
+	OwnerName    string
+	Name         string
+	Visibility   Visibility
+	Created      time.Time
+	SnippetCount int      // Live snippets only, like Snippets.
+	Snippets     Snippets // Set by GetCollection, in order.
+}
+
+// VisibleTo reports whether the collection can be seen by user, who is nil
+// for an anonymous visitor.
+func (c *Collection) VisibleTo(user *User) bool {
+	return c.Visibility != Private || c.OwnedBy(user)
+}
+
+// OwnedBy reports whether user, who may be nil, owns the collection.
+func (c *Collection) OwnedBy(user *User) bool {
+	return user != nil && user.ID == c.UserID
+}
+
+type Collections []*Collection
+
+// InsertCollection creates an empty collection and returns its ID.
+func (db *Database) InsertCollection(ctx context.Context, userID int, name string, visibility Visibility) (int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	stmt := `INSERT INTO collections (user_id, name, visibility, created) VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	result, err := db.ExecContext(ctx, stmt, userID, name, visibility)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// GetCollection fetches a collection with its live snippets, in order. Like
+// GetSnippet it returns nil (and no error) if there's no such collection; it's
+// up to the caller to check the collection is visible to whoever asked.
+func (db *Database) GetCollection(ctx context.Context, id int) (*Collection, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	stmt := `SELECT c.id, c.user_id, u.name, c.name, c.visibility, c.created
+		FROM collections c JOIN users u ON u.id = c.user_id WHERE c.id = ?`
+	c := &Collection{}
+	err := db.QueryRowContext(ctx, stmt, id).Scan(&c.ID, &c.UserID, &c.OwnerName, &c.Name,
+		&c.Visibility, &c.Created)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	stmt = `SELECT s.id, s.title, s.content, s.created, s.expires, COALESCE(s.key_id, ''), s.data_key, s.e2e
+		FROM collection_snippets cs JOIN snippets s ON s.id = cs.snippet_id
+		WHERE cs.collection_id = ? AND s.expires > UTC_TIMESTAMP() AND s.removed IS NULL
+		ORDER BY cs.position`
+	rows, err := db.QueryContext(ctx, stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		s := &Snippet{}
+		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.keyID, &s.wrappedKey, &s.E2E)
+		if err != nil {
+			return nil, err
+		}
+		c.Snippets = append(c.Snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	c.SnippetCount = len(c.Snippets)
+	// One snippet that can't be decrypted shouldn't take the whole
+	// collection down with it, so it's marked and shown without its content.
+	for _, s := range c.Snippets {
+		if db.openSnippets(s) != nil {
+			s.Content, s.Unreadable = "", true
+		}
+	}
+	return c, db.loadTags(ctx, c.Snippets...)
+}
+
+// UserCollections returns all of a user's collections, newest first.
+func (db *Database) UserCollections(ctx context.Context, userID int) (Collections, error) {
+	return db.listCollections(ctx, `WHERE c.user_id = ? ORDER BY c.created DESC`, userID)
+}
+
+// PublicCollections returns the latest public collections.
+func (db *Database) PublicCollections(ctx context.Context, limit int) (Collections, error) {
+	return db.listCollections(ctx, `WHERE c.visibility = 'public' ORDER BY c.created DESC LIMIT ?`, limit)
+}
+
+func (db *Database) listCollections(ctx context.Context, where string, args ...interface{}) (Collections, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	stmt := `SELECT c.id, c.user_id, u.name, c.name, c.visibility, c.created,
+			(SELECT COUNT(*) FROM collection_snippets cs JOIN snippets s ON s.id = cs.snippet_id
+				WHERE cs.collection_id = c.id AND s.expires > UTC_TIMESTAMP() AND s.removed IS NULL)
+		FROM collections c JOIN users u ON u.id = c.user_id ` + where
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	collections := Collections{}
+	for rows.Next() {
+		c := &Collection{}
+		err := rows.Scan(&c.ID, &c.UserID, &c.OwnerName, &c.Name, &c.Visibility, &c.Created,
+			&c.SnippetCount)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+// UpdateCollection renames a collection and changes its visibility.
+func (db *Database) UpdateCollection(ctx context.Context, id int, name string, visibility Visibility) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	_, err := db.ExecContext(ctx, `UPDATE collections SET name = ?, visibility = ? WHERE id = ?`,
+		name, visibility, id)
+	return err
+}
+
+// DeleteCollection deletes a collection. The snippets in it are left alone.
+func (db *Database) DeleteCollection(ctx context.Context, id int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	_, err := db.ExecContext(ctx, `DELETE FROM collections WHERE id = ?`, id)
+	return err
+}
+
+// AddToCollection puts a live snippet at the end of a collection. It returns
+// ErrNoRecord if there's no such snippet; adding one that's already there
+// does nothing.
+func (db *Database) AddToCollection(ctx context.Context, collectionID, snippetID int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the collection, so that two snippets added at once can't both be
+	// given the same last position.
+	var id int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM collections WHERE id = ? FOR UPDATE`, collectionID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrNoRecord
+	} else if err != nil {
+		return err
+	}
+
+	stmt := `INSERT IGNORE INTO collection_snippets (collection_id, snippet_id, position)
+		SELECT ?, s.id, COALESCE((SELECT MAX(position) FROM collection_snippets WHERE collection_id = ?), 0) + 1
+		FROM snippets s WHERE s.id = ? AND s.expires > UTC_TIMESTAMP() AND s.removed IS NULL`
+	result, err := tx.ExecContext(ctx, stmt, collectionID, collectionID, snippetID)
+	if err != nil {
+		return err
+	}
+	// Zero rows means either no such snippet or already there; tell them apart.
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		var exists bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM collection_snippets
+			WHERE collection_id = ? AND snippet_id = ?)`, collectionID, snippetID).Scan(&exists)
+		if err != nil {
+			return err
+		} else if !exists {
+			return ErrNoRecord
+		}
+	}
+	return tx.Commit()
+}
+
+// RemoveFromCollection takes a snippet out of a collection.
+func (db *Database) RemoveFromCollection(ctx context.Context, collectionID, snippetID int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	_, err := db.ExecContext(ctx, `DELETE FROM collection_snippets WHERE collection_id = ? AND snippet_id = ?`,
+		collectionID, snippetID)
+	return err
+}
+
+// MoveInCollection moves a snippet one place up (delta < 0) or down (delta > 0)
+// in a collection, by swapping it with its neighbour. Moving the first
+// snippet up or the last one down does nothing. Expired and removed snippets
+// don't count as neighbours, since GetCollection doesn't show them.
+func (db *Database) MoveInCollection(ctx context.Context, collectionID, snippetID, delta int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	var position int
+	err = tx.QueryRowContext(ctx, `SELECT position FROM collection_snippets
+		WHERE collection_id = ? AND snippet_id = ? FOR UPDATE`, collectionID, snippetID).Scan(&position)
+	if err == sql.ErrNoRows {
+		return ErrNoRecord
+	} else if err != nil {
+		return err
+	}
+
+	stmt := `SELECT cs.snippet_id, cs.position FROM collection_snippets cs
+		INNER JOIN snippets s ON s.id = cs.snippet_id
+		WHERE cs.collection_id = ? AND cs.position > ?
+		AND s.expires > UTC_TIMESTAMP() AND s.removed IS NULL
+		ORDER BY cs.position LIMIT 1 FOR UPDATE`
+	if delta < 0 {
+		stmt = `SELECT cs.snippet_id, cs.position FROM collection_snippets cs
+			INNER JOIN snippets s ON s.id = cs.snippet_id
+			WHERE cs.collection_id = ? AND cs.position < ?
+			AND s.expires > UTC_TIMESTAMP() AND s.removed IS NULL
+			ORDER BY cs.position DESC LIMIT 1 FOR UPDATE`
+	}
+	var otherID, otherPosition int
+	err = tx.QueryRowContext(ctx, stmt, collectionID, position).Scan(&otherID, &otherPosition)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	stmt = `UPDATE collection_snippets SET position = ? WHERE collection_id = ? AND snippet_id = ?`
+	if _, err = tx.ExecContext(ctx, stmt, otherPosition, collectionID, snippetID); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, stmt, position, collectionID, otherID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
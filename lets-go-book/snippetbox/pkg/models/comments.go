@@ -0,0 +1,159 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// MaxCommentLength is the longest comment, in characters.
+const MaxCommentLength = 5000
+
+// A Comment is a user's comment on a snippet, or their reply to another
+// comment on it.
+type Comment struct {
+	ID        int
+	SnippetID int
+	UserID    int
+	UserName  string
+	ParentID  int    // The comment this replies to, or 0.
+	Line      int    // The line of the snippet it's about, or 0 for all of it.
+	Body      string // Empty once the comment is deleted or removed.
+	Created   time.Time
+	Edited    time.Time // Zero if it never was.
+	Deleted   bool      // By its author.
+	Removed   bool      // By a moderator.
+	Depth     int       // How deep in its thread it is; set by SnippetComments.
+	// SnippetE2E is whether its snippet is end-to-end encrypted; set by
+	// GetComment.
+	SnippetE2E bool
+}
+
+// Live reports whether the comment can still be read, edited or replied to.
+func (c *Comment) Live() bool {
+	return !c.Deleted && !c.Removed
+}
+
+// OwnedBy reports whether user, who may be nil, wrote the comment.
+func (c *Comment) OwnedBy(user *User) bool {
+	return user != nil && user.ID == c.UserID
+}
+
+type Comments []*Comment
+
+// InsertComment adds a comment to a snippet and returns its ID. parentID and
+// line are 0 for a top-level comment on the whole snippet; it's up to the
+// caller to check they make sense.
+func (db *Database) InsertComment(ctx context.Context, snippetID, userID, parentID, line int, body string) (int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	stmt := `INSERT INTO comments (snippet_id, user_id, parent_id, line, body, created)
+		VALUES(?, ?, NULLIF(?, 0), NULLIF(?, 0), ?, UTC_TIMESTAMP())`
+	result, err := db.ExecContext(ctx, stmt, snippetID, userID, parentID, line, body)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+const commentColumns = `c.id, c.snippet_id, c.user_id, u.name, COALESCE(c.parent_id, 0), COALESCE(c.line, 0),
+	c.body, c.created, c.edited, c.deleted IS NOT NULL, c.removed IS NOT NULL`
+
+func scanComment(scan func(...interface{}) error) (*Comment, error) {
+	c := &Comment{}
+	var edited sql.NullTime
+	err := scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName, &c.ParentID, &c.Line,
+		&c.Body, &c.Created, &edited, &c.Deleted, &c.Removed)
+	if err != nil {
+		return nil, err
+	}
+	c.Edited = edited.Time
+	if !c.Live() {
+		c.Body = ""
+	}
+	return c, nil
+}
+
+// GetComment returns the comment with the given ID, or nil if there isn't one.
+func (db *Database) GetComment(ctx context.Context, id int) (*Comment, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	stmt := `SELECT ` + commentColumns + `, s.e2e FROM comments c JOIN users u ON u.id = c.user_id
+		JOIN snippets s ON s.id = c.snippet_id WHERE c.id = ?`
+	row := db.QueryRowContext(ctx, stmt, id)
+	var e2e bool
+	c, err := scanComment(func(dest ...interface{}) error {
+		return row.Scan(append(dest, &e2e)...)
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	c.SnippetE2E = e2e
+	return c, nil
+}
+
+// SnippetComments returns the discussion on a snippet, in reading order: the
+// top-level comments oldest first, each followed by its replies (and theirs),
+// with Depth saying how far to indent them.
+func (db *Database) SnippetComments(ctx context.Context, snippetID int) (Comments, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	stmt := `SELECT ` + commentColumns + ` FROM comments c JOIN users u ON u.id = c.user_id
+		WHERE c.snippet_id = ? ORDER BY c.created, c.id`
+	rows, err := db.QueryContext(ctx, stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	replies := map[int]Comments{} // Keyed by parent ID; 0 for the top level.
+	for rows.Next() {
+		c, err := scanComment(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		replies[c.ParentID] = append(replies[c.ParentID], c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	comments := Comments{}
+	var walk func(parentID, depth int)
+	walk = func(parentID, depth int) {
+		for _, c := range replies[parentID] {
+			c.Depth = depth
+			comments = append(comments, c)
+			walk(c.ID, depth+1)
+		}
+	}
+	walk(0, 0)
+	return comments, nil
+}
+
+// UpdateComment changes the text of a live comment and marks it as edited.
+func (db *Database) UpdateComment(ctx context.Context, id int, body string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	stmt := `UPDATE comments SET body = ?, edited = UTC_TIMESTAMP()
+		WHERE id = ? AND deleted IS NULL AND removed IS NULL`
+	_, err := db.ExecContext(ctx, stmt, body, id)
+	return err
+}
+
+// DeleteComment is for a comment's author to take it back. Its replies stay.
+func (db *Database) DeleteComment(ctx context.Context, id int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	_, err := db.ExecContext(ctx, `UPDATE comments SET deleted = UTC_TIMESTAMP() WHERE id = ? AND deleted IS NULL`, id)
+	return err
+}
+
+// RemoveComment hides a comment on behalf of the moderator actorID,
+// recording the action in the audit log.
+func (db *Database) RemoveComment(ctx context.Context, actorID, id int) error {
+	return db.moderate(ctx, actorID, ActionRemoveComment, "comment", id, "",
+		`UPDATE comments SET removed = UTC_TIMESTAMP() WHERE id = ? AND removed IS NULL`, id)
+}
@@ -1,18 +1,21 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // Create a new ErrInvalidCredentials error that we can return.
 // Declare a custom error to return if a duplicate email is added.
 var (
+	ErrAccountDisabled    = errors.New("models: user account is disabled")
 	ErrDuplicateEmail     = errors.New("models: email address already in use")
 	ErrInvalidCredentials = errors.New("models: invalid user credentials")
+	ErrNoRecord           = errors.New("models: no matching record found")
 )
 
 // 1. Declare a Database type (struct in this case)
@@ -20,37 +23,82 @@ var (
 // later access its methods from GetSnippet().
 type Database struct {
 	*sql.DB // Can be empty if testing database with hard-coded data...
+	// Passwords hashes and verifies user passwords. If nil, bcrypt with a cost
+	// of 12 is used.
+	Passwords *PasswordHasher
+	// Keys encrypts new snippets' content and attachments, and decrypts
+	// them again. If nil they are stored as they are, and encrypted ones
+	// can't be read.
+	Keys *Keyring
+	// QueryTimeout bounds how long each model method may spend in the
+	// database, on top of any deadline the caller's context already has. Zero
+	// means no extra limit.
+	QueryTimeout time.Duration
+}
+
+// withTimeout derives the context a model method runs its queries with.
+func (db *Database) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.QueryTimeout)
 }
 
 // Implement a GetSnippet() method on the Database type. For now, this just returns
 // some dummy data, but later we'll update it to query our MySQL database for a
 // snippet with a specific ID. In particular, it returns a dummy snippet if the id
 // passed to the method equals 123, or returns nil otherwise.
-func (db *Database) GetSnippet(id int) (*Snippet, error) {
+func (db *Database) GetSnippet(ctx context.Context, id int) (*Snippet, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-		WHERE expires > UTC_TIMESTAMP() AND id = ?` // ? --> placeholder parameter
+	stmt := `SELECT id, title, content, COALESCE(key_id, ''), data_key, e2e, format, created, expires, stars,
+			COALESCE(user_id, 0), COALESCE(parent_id, 0),
+			(SELECT COUNT(*) FROM snippets f
+				WHERE f.parent_id = s.id AND f.expires > UTC_TIMESTAMP() AND f.removed IS NULL)
+		FROM snippets s
+		WHERE expires > UTC_TIMESTAMP() AND removed IS NULL AND id = ?` // ? --> placeholder parameter
 
 	// This returns a pointer to a sql.Row object which holds the result returned
 	// by the database.
-	row := db.QueryRow(stmt, id) // 1. Prepares the statement, 2. Passes parameter, 3. Close
+	row := db.QueryRowContext(ctx, stmt, id) // 1. Prepares the statement, 2. Passes parameter, 3. Close
 
 	s := &Snippet{}
 
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.keyID, &s.wrappedKey, &s.E2E, &s.Format, &s.Created, &s.Expires, &s.Stars, &s.UserID,
+		&s.ParentID, &s.Forks)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
 		return nil, err
 	}
-	// If everything went OK then return the Snippet object.
-	return s, nil
+	// If everything went OK then decrypt it, add its tags, files and
+	// attachments and return the Snippet object.
+	err = db.openSnippets(s)
+	if err != nil {
+		return nil, err
+	}
+	err = db.loadTags(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	err = db.loadFiles(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	return s, db.loadAttachments(ctx, s)
 }
 
-func (db *Database) LatestSnippets() (Snippets, error) {
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-		WHERE expires > UTC_TIMESTAMP() ORDER BY created DESC LIMIT 10`
-	rows, err := db.Query(stmt)
+// LatestSnippets returns the 10 newest live snippets, for the homepage. Like
+// the other listings, it leaves out their content (which the listings don't
+// show), so it has nothing to decrypt.
+func (db *Database) LatestSnippets(ctx context.Context) (Snippets, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	stmt := `SELECT id, title, created, expires FROM snippets
+		WHERE expires > UTC_TIMESTAMP() AND removed IS NULL
+		ORDER BY created DESC LIMIT 10`
+	rows, err := db.QueryContext(ctx, stmt)
 	if err != nil {
 		return nil, err
 	}
@@ -58,7 +106,7 @@ func (db *Database) LatestSnippets() (Snippets, error) {
 	snippets := Snippets{}
 	for rows.Next() {
 		s := &Snippet{}
-		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		err := rows.Scan(&s.ID, &s.Title, &s.Created, &s.Expires)
 		if err != nil {
 			return nil, err
 		}
@@ -67,15 +115,43 @@ func (db *Database) LatestSnippets() (Snippets, error) {
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
-	return snippets, nil
+	return snippets, db.loadTags(ctx, snippets...)
 }
 
-func (db *Database) InsertSnippet(title, content, expires string) (int, error) {
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-		VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+// InsertSnippet adds a snippet, with its (normalized) tags, its files and its
+// attachments, in one transaction so a snippet is never saved half done. The
+// snippet's UserID is who created it and, for a fork, ParentID is the snippet
+// it was forked from. expires is its lifetime in seconds.
+func (db *Database) InsertSnippet(ctx context.Context, s *Snippet, expires string) (int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	// The content is encrypted with a data key of its own, if we have keys.
+	// It's sealed with the snippet's ID, which we only know once the row is
+	// in, so it's filled in afterwards.
+	dataKey, keyID, wrappedKey, err := db.newDataKey()
+	if err != nil {
+		return 0, err
+	}
+	content := s.Content
+	if dataKey != nil {
+		content = ""
+	}
 
-	result, err := db.Exec(stmt, title, content, expires)
-	// db.Exec will result sql.Result
+	// A ParentID of 0 means it isn't a fork.
+	stmt := `INSERT INTO snippets (user_id, parent_id, title, content, key_id, data_key, e2e, format, created, expires)
+		VALUES(?, NULLIF(?, 0), ?, ?, NULLIF(?, ''), ?, ?, ?, UTC_TIMESTAMP(),
+			DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+
+	result, err := tx.ExecContext(ctx, stmt, s.UserID, s.ParentID, s.Title, content, keyID, wrappedKey, s.E2E,
+		s.Format, expires)
+	// tx.ExecContext will result sql.Result
 
 	if err != nil {
 		return 0, err
@@ -86,64 +162,162 @@ func (db *Database) InsertSnippet(title, content, expires string) (int, error) {
 		return 0, err
 	}
 
+	if dataKey != nil {
+		content, err = sealText(dataKey, s.Content, snippetAAD(int(id)))
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE snippets SET content = ? WHERE id = ?`, content, id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = insertTags(ctx, tx, int(id), s.Tags)
+	if err != nil {
+		return 0, err
+	}
+	err = insertFiles(ctx, tx, int(id), s.Files, dataKey)
+	if err != nil {
+		return 0, err
+	}
+	err = insertAttachments(ctx, tx, int(id), s.Attachments)
+	if err != nil {
+		return 0, err
+	}
+
 	// The ID returned is of type int64, so we convert it to an int for returning purposes.
-	return int(id), nil
+	return int(id), tx.Commit()
 }
 
-// NOTE: It's important realize that calls to db.Exec(), db.QueryRow() and db.Query() can use
-//any connection from the pool. Even if you have two calls to db.Exec() immediately next to
+// NOTE: It's important realize that calls to db.ExecContext(), db.QueryRowContext() and db.QueryContext() can use
+//any connection from the pool. Even if you have two calls to db.ExecContext() immediately next to
 // each other in your code, there is no guarantee that they will use the same database connection.
 
 // To guarantee that the same connection is used you can wrap multiple statements in a transaction
-// tx, err := db.Begin()
+// tx, err := db.begin(ctx)
 
-func (db *Database) InsertUser(name, email, password string) error {
-	// Create a bcrypt hash of the plain-text password.
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+func (db *Database) InsertUser(ctx context.Context, name, email, password string) error {
+	// Hash the plain-text password with the configured algorithm and cost.
+	// That's deliberately slow, so it happens before the query timeout starts.
+	hashedPassword, err := db.Passwords.Hash(password)
 	if err != nil {
 		return err
 	}
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
 	stmt := `INSERT INTO users (name, email, password, created)
 		VALUES(?, ?, ?, UTC_TIMESTAMP())`
 
-	// Insert the user details and hashed password into the users table. If there
-	// we type assert it to a *mysql.MySQLError object so we can check its
-	// specific error number. If it's error 1062 we return the ErrDuplicateEmail
-	// error instead of the one from MySQL.
-	_, err = db.Exec(stmt, name, email, string(hashedPassword))
-	if err != nil {
-		if err.(*mysql.MySQLError).Number == 1062 {
-			return ErrDuplicateEmail
-		}
+	// Insert the user details and hashed password into the users table. If
+	// the error is a *mysql.MySQLError we check its specific error number, and
+	// if it's error 1062 we return the ErrDuplicateEmail error instead of the
+	// one from MySQL. Anything else (a timeout, a dropped connection) isn't a
+	// MySQLError at all, so it's returned unchanged.
+	_, err = db.ExecContext(ctx, stmt, name, email, hashedPassword)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		return ErrDuplicateEmail
 	}
 	return err
 }
 
 // (db *Database) VerifyUser() method to our database model which does two things:
 // 1. Retrieve the hashed password associated with the email if exists / else error
-// 2. Compare the bcrpyt hashed password to the plain-text password that the user provided
+// 2. Compare the hashed password to the plain-text password that the user provided
 //    If match, return user ID / else error
-func (db *Database) VerifyUser(email, password string) (int, error) {
+func (db *Database) VerifyUser(ctx context.Context, email, password string) (int, error) {
 	// Retrieve the id and hashed password associated with the given email. If no
-	// matching email exists, we return the ErrInvalidCredentials error.
+	// matching email exists, we return the ErrInvalidCredentials error. The
+	// query timeout only covers the queries, not the (slow) hashing between
+	// them.
 	var id int
-	var hashedPassword []byte
-	row := db.QueryRow("SELECT id, password FROM users WHERE email = ?", email)
-	err := row.Scan(&id, &hashedPassword)
+	var hashedPassword string
+	var disabled bool
+	queryCtx, cancel := db.withTimeout(ctx)
+	row := db.QueryRowContext(queryCtx, "SELECT id, password, disabled FROM users WHERE email = ?", email)
+	err := row.Scan(&id, &hashedPassword, &disabled)
+	cancel()
 	if err == sql.ErrNoRows {
 		return 0, ErrInvalidCredentials
 	} else if err != nil {
 		return 0, err
 	}
+	// Users created through single sign-on have no password at all.
+	if hashedPassword == "" {
+		return 0, ErrInvalidCredentials
+	}
 	// Check whether the hashed password and plain-text password provided match.
 	// If they don't, we return the ErrInvalidCredentials error.
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
-	if err == bcrypt.ErrMismatchedHashAndPassword {
-		return 0, ErrInvalidCredentials
-	} else if err != nil {
+	match, rehash, err := db.Passwords.Verify(hashedPassword, password)
+	if err != nil {
 		return 0, err
+	} else if !match {
+		return 0, ErrInvalidCredentials
+	}
+	// Only tell the user their account is disabled once they have proven who
+	// they are, so the error can't be used to probe for accounts.
+	if disabled {
+		return 0, ErrAccountDisabled
+	}
+	// This is the only time we see the plain-text password, so take the chance
+	// to upgrade hashes made with an older algorithm or a lower cost. A failure
+	// here shouldn't stop the user logging in; we'll try again next time.
+	if rehash {
+		if newHash, err := db.Passwords.Hash(password); err == nil {
+			ctx, cancel := db.withTimeout(ctx)
+			db.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ?", newHash, id)
+			cancel()
+		}
 	}
 	// Otherwise, the password is correct. Return the user ID.
 	return id, nil
 }
+
+// GetUser fetches the details of a user by ID. Like GetSnippet it returns nil
+// (and no error) if there's no matching record.
+func (db *Database) GetUser(ctx context.Context, id int) (*User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	stmt := `SELECT id, name, email, role, disabled, locale, time_zone, created FROM users WHERE id = ?`
+	u := &User{}
+	err := db.QueryRowContext(ctx, stmt, id).Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.Disabled,
+		&u.Locale, &u.TimeZone, &u.Created)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// SetUserLocale saves the language the user has chosen.
+func (db *Database) SetUserLocale(ctx context.Context, id int, locale string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	_, err := db.ExecContext(ctx, `UPDATE users SET locale = ? WHERE id = ?`, locale, id)
+	return err
+}
+
+// SetUserTimeZone saves the time zone the user has chosen ("" to go by their
+// browser).
+func (db *Database) SetUserTimeZone(ctx context.Context, id int, timeZone string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	_, err := db.ExecContext(ctx, `UPDATE users SET time_zone = ? WHERE id = ?`, timeZone, id)
+	return err
+}
+
+// SchemaVersion is the newest migration (see the migrations directory) this
+// code relies on. Bump it whenever a migration is added.
+const SchemaVersion = 18
+
+// MigrationVersion returns the newest migration applied to the database.
+func (db *Database) MigrationVersion(ctx context.Context) (int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	var version int
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
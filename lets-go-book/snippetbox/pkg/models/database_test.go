@@ -0,0 +1,35 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestInsertUserErrors(t *testing.T) {
+	tableMissing := &mysql.MySQLError{Number: 1146, Message: "Table doesn't exist"}
+	tests := []struct {
+		name    string
+		err     error
+		wantErr error
+	}{
+		{"duplicate email", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, ErrDuplicateEmail},
+		{"other MySQL error", tableMissing, tableMissing},
+		{"timeout", context.DeadlineExceeded, context.DeadlineExceeded},
+		{"bad connection", mysql.ErrInvalidConn, mysql.ErrInvalidConn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDatabase(t, nil)
+			db.Passwords = testBcrypt
+			mock.ExpectExec("INSERT INTO users").WillReturnError(tt.err)
+
+			err := db.InsertUser(context.Background(), "Alice", "alice@example.com", "correct horse")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("InsertUser() = %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,426 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"hash"
+	"io"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/blob"
+)
+
+// openSnippets decrypts the content of any of the snippets that are
+// encrypted, keeping the data key for their files. Snippets from before
+// encryption was turned on are left as they are.
+func (db *Database) openSnippets(snippets ...*Snippet) error {
+	for _, s := range snippets {
+		if s.keyID == "" {
+			continue
+		}
+		dataKey, err := db.Keys.unwrap(s.keyID, s.wrappedKey)
+		if err != nil {
+			return err
+		}
+		s.Content, err = openText(dataKey, s.Content, snippetAAD(s.ID))
+		if err != nil {
+			return err
+		}
+		s.dataKey = dataKey
+	}
+	return nil
+}
+
+// newDataKey returns a data key for a new snippet, with the ID of the key it
+// is wrapped with and the wrapped key to store. They're all empty if there
+// is no Keyring, in which case the snippet is stored as it is.
+func (db *Database) newDataKey() (dataKey []byte, keyID string, wrapped []byte, err error) {
+	if db.Keys == nil {
+		return nil, "", nil, nil
+	}
+	return db.Keys.newDataKey()
+}
+
+// sealedMagic starts every encrypted blob, to tell them from the blobs
+// stored before encryption was turned on.
+const sealedMagic = "SBX1"
+
+// sealedStore encrypts blobs on their way into the store, and decrypts them
+// on the way out, each with its own data key from the blob_keys table. Blobs
+// are read into memory to do it, which the upload size limit keeps small.
+type sealedStore struct {
+	blob.Store
+	db *Database
+}
+
+// SealBlobs returns a store which encrypts the blobs it puts in s with data
+// keys from the Keyring, and decrypts them again when they're opened. Blobs
+// that were put in s before (or without) a Keyring are read as they are.
+func (db *Database) SealBlobs(s blob.Store) blob.Store {
+	return &sealedStore{Store: s, db: db}
+}
+
+// NewHash makes blob.Save key new blobs with the Keyring's HMAC rather than
+// a plain SHA-256 of their content.
+func (s *sealedStore) NewHash() hash.Hash {
+	if s.db.Keys == nil {
+		return sha256.New()
+	}
+	return s.db.Keys.blobHash()
+}
+
+func (s *sealedStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if s.db.Keys == nil {
+		return s.Store.Put(ctx, key, r, size)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	// The data key is recorded before the blob is written, so anyone reading
+	// it in between finds it isn't sealed yet and takes it as it is. The blob
+	// is only marked sealed once it has been written; until then RotateKeys
+	// treats it as unencrypted.
+	dataKey, err := s.db.blobDataKey(ctx, key, true)
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+	// The blob's key is authenticated too, so one blob can't be swapped for
+	// another.
+	sealed, err := seal(aead, data, []byte(key))
+	if err != nil {
+		return err
+	}
+	sealed = append([]byte(sealedMagic), sealed...)
+	err = s.Store.Put(ctx, key, bytes.NewReader(sealed), int64(len(sealed)))
+	if err != nil {
+		return err
+	}
+	_, err = s.db.exec(ctx, `UPDATE blob_keys SET sealed = TRUE WHERE blob_key = ?`, key)
+	return err
+}
+
+func (s *sealedStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	rc, err := s.Store.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	plaintext := io.NopCloser(bytes.NewReader(data))
+	if !bytes.HasPrefix(data, []byte(sealedMagic)) {
+		return plaintext, nil
+	}
+	// An unencrypted file could start with the magic too, but it won't have a
+	// data key.
+	dataKey, err := s.db.blobDataKey(ctx, key, false)
+	if err != nil || dataKey == nil {
+		return plaintext, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err = open(aead, data[len(sealedMagic):], []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// blobDataKey returns the data key for the blob with the key, or nil if it
+// hasn't got one. With create, a blob without one is given one; if two
+// uploads race to do that the first wins, and both use its key.
+func (db *Database) blobDataKey(ctx context.Context, key string, create bool) ([]byte, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	if create {
+		_, keyID, wrapped, err := db.Keys.newDataKey()
+		if err != nil {
+			return nil, err
+		}
+		_, err = db.ExecContext(ctx, `INSERT IGNORE INTO blob_keys (blob_key, key_id, data_key)
+			VALUES (?, ?, ?)`, key, keyID, wrapped)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var keyID string
+	var wrapped []byte
+	err := db.QueryRowContext(ctx, `SELECT key_id, data_key FROM blob_keys WHERE blob_key = ?`, key).
+		Scan(&keyID, &wrapped)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return db.Keys.unwrap(keyID, wrapped)
+}
+
+// Rotation counts what RotateKeys did.
+type Rotation struct {
+	SnippetsRewrapped int // Data keys re-wrapped with the current key.
+	SnippetsEncrypted int // Snippets from before encryption, now encrypted.
+	BlobsRewrapped    int
+	BlobsEncrypted    int
+}
+
+// rotateBatch is how many rows RotateKeys reads at a time.
+const rotateBatch = 100
+
+// RotateKeys re-wraps every data key that isn't wrapped with the Keyring's
+// current key, and encrypts the snippets (and, if blobs isn't nil, the
+// attachment blobs) stored before encryption was turned on. It works a row at
+// a time while the site is up: each change is atomic, and the site can read
+// rows either side of it as long as it has all the keys in use. Once it has
+// finished, keys other than the current one can be retired.
+func (db *Database) RotateKeys(ctx context.Context, blobs blob.Store) (*Rotation, error) {
+	if db.Keys == nil {
+		return nil, errors.New("models: no keys to rotate to")
+	}
+	r := &Rotation{}
+	var err error
+	r.SnippetsRewrapped, err = db.rewrap(ctx, `SELECT id, key_id, data_key FROM snippets
+		WHERE key_id IS NOT NULL AND key_id <> ? AND id > ? ORDER BY id LIMIT ?`,
+		`UPDATE snippets SET key_id = ?, data_key = ? WHERE id = ? AND key_id = ?`)
+	if err != nil {
+		return r, err
+	}
+	r.BlobsRewrapped, err = db.rewrap(ctx, `SELECT blob_key, key_id, data_key FROM blob_keys
+		WHERE key_id <> ? AND blob_key > ? ORDER BY blob_key LIMIT ?`,
+		`UPDATE blob_keys SET key_id = ?, data_key = ? WHERE blob_key = ? AND key_id = ?`)
+	if err != nil {
+		return r, err
+	}
+
+	r.SnippetsEncrypted, err = db.encryptSnippets(ctx)
+	if err != nil || blobs == nil {
+		return r, err
+	}
+	r.BlobsEncrypted, err = db.encryptBlobs(ctx, blobs)
+	return r, err
+}
+
+// rewrap re-wraps the data keys that the query (given the current key ID,
+// the last row seen and a limit) finds, with update (given the new key ID and
+// wrapped key, the row and its old key ID). Rows are identified by a string,
+// which MySQL turns back into a number for the snippets' IDs.
+func (db *Database) rewrap(ctx context.Context, query, update string) (int, error) {
+	type row struct {
+		id, keyID string
+		wrapped   []byte
+	}
+	var n int
+	var last string
+	for {
+		var batch []row
+		err := db.eachRow(ctx, query, []interface{}{db.Keys.Current(), last, rotateBatch}, func(rows *sql.Rows) error {
+			var r row
+			err := rows.Scan(&r.id, &r.keyID, &r.wrapped)
+			batch = append(batch, r)
+			return err
+		})
+		if err != nil || len(batch) == 0 {
+			return n, err
+		}
+		for _, r := range batch {
+			wrapped, err := db.Keys.rewrap(r.keyID, r.wrapped)
+			if err != nil {
+				return n, err
+			}
+			// The old key ID is checked again, in case someone else got there
+			// first.
+			result, err := db.exec(ctx, update, db.Keys.Current(), wrapped, r.id, r.keyID)
+			if err != nil {
+				return n, err
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return n, err
+			}
+			n += int(affected)
+			last = r.id
+		}
+		if len(batch) < rotateBatch {
+			return n, nil
+		}
+	}
+}
+
+// encryptSnippets encrypts the snippets stored before encryption was turned
+// on: the ones without a key ID.
+func (db *Database) encryptSnippets(ctx context.Context) (int, error) {
+	var n, last int
+	for {
+		ids, err := db.queryIDs(ctx, `SELECT id FROM snippets WHERE key_id IS NULL AND id > ?
+			ORDER BY id LIMIT ?`, last, rotateBatch)
+		if err != nil || len(ids) == 0 {
+			return n, err
+		}
+		for _, id := range ids {
+			encrypted, err := db.encryptSnippet(ctx, id)
+			if err != nil {
+				return n, err
+			}
+			if encrypted {
+				n++
+			}
+			last = id
+		}
+	}
+}
+
+// encryptSnippet encrypts a snippet stored before encryption was turned on,
+// and its files, in one transaction. It reports false if the snippet turned
+// out to be encrypted already.
+func (db *Database) encryptSnippet(ctx context.Context, id int) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var content string
+	err = tx.QueryRowContext(ctx, `SELECT content FROM snippets WHERE id = ? AND key_id IS NULL FOR UPDATE`, id).
+		Scan(&content)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	dataKey, keyID, wrapped, err := db.Keys.newDataKey()
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT position, content FROM snippet_files WHERE snippet_id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	files := map[int]string{}
+	for rows.Next() {
+		var position int
+		var content string
+		if err := rows.Scan(&position, &content); err != nil {
+			rows.Close()
+			return false, err
+		}
+		files[position] = content
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	for position, content := range files {
+		sealed, err := sealText(dataKey, content, fileAAD(id, position))
+		if err != nil {
+			return false, err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE snippet_files SET content = ? WHERE snippet_id = ? AND position = ?`,
+			sealed, id, position)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	sealed, err := sealText(dataKey, content, snippetAAD(id))
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE snippets SET content = ?, key_id = ?, data_key = ? WHERE id = ?`,
+		sealed, keyID, wrapped, id)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// encryptBlobs encrypts the attachment blobs stored before encryption was
+// turned on, the ones without a data key, and any whose encrypted write
+// didn't finish. The latter may be sealed already, or not; they're opened
+// through the sealed store, which tells the two apart, and written again.
+func (db *Database) encryptBlobs(ctx context.Context, blobs blob.Store) (int, error) {
+	sealed := db.SealBlobs(blobs)
+	var n int
+	var last string
+	for {
+		var keys []string
+		err := db.eachRow(ctx, `SELECT DISTINCT a.blob_key FROM attachments a
+			LEFT JOIN blob_keys b ON b.blob_key = a.blob_key
+			WHERE (b.blob_key IS NULL OR NOT b.sealed) AND a.blob_key > ? ORDER BY a.blob_key LIMIT ?`,
+			[]interface{}{last, rotateBatch}, func(rows *sql.Rows) error {
+				var key string
+				err := rows.Scan(&key)
+				keys = append(keys, key)
+				return err
+			})
+		if err != nil || len(keys) == 0 {
+			return n, err
+		}
+		for _, key := range keys {
+			last = key
+			rc, err := sealed.Open(ctx, key)
+			if errors.Is(err, blob.ErrNotFound) {
+				continue
+			} else if err != nil {
+				return n, err
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return n, err
+			}
+			err = sealed.Put(ctx, key, bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+}
+
+// eachRow runs the query and calls fn for each row.
+func (db *Database) eachRow(ctx context.Context, query string, args []interface{}, fn func(*sql.Rows) error) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *Database) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	return db.ExecContext(ctx, query, args...)
+}
+
+// queryIDs returns the IDs the query finds.
+func (db *Database) queryIDs(ctx context.Context, query string, args ...interface{}) ([]int, error) {
+	var ids []int
+	err := db.eachRow(ctx, query, args, func(rows *sql.Rows) error {
+		var id int
+		err := rows.Scan(&id)
+		ids = append(ids, id)
+		return err
+	})
+	return ids, err
+}
@@ -0,0 +1,298 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/noelruault/lets-go/snippetbox/pkg/blob"
+)
+
+// newMockDatabase returns a Database on a mock connection, with the keys.
+func newMockDatabase(t *testing.T, keys *Keyring) (*Database, sqlmock.Sqlmock) {
+	t.Helper()
+	sqldb, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		sqldb.Close()
+	})
+	return &Database{DB: sqldb, Keys: keys}, mock
+}
+
+// recorded is a query argument that matches anything, and remembers it.
+type recorded struct{ v *driver.Value }
+
+func (r recorded) Match(v driver.Value) bool {
+	*r.v = v
+	return true
+}
+
+// expectBlobKey expects sealedStore to look up (and maybe create) the blob's
+// data key, and gives it wrapped.
+func expectBlobKey(mock sqlmock.Sqlmock, key, keyID string, wrapped []byte) {
+	mock.ExpectExec("INSERT IGNORE INTO blob_keys").WithArgs(key, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectBlobKeyLookup(mock, key, keyID, wrapped)
+}
+
+func expectBlobKeyLookup(mock sqlmock.Sqlmock, key, keyID string, wrapped []byte) {
+	mock.ExpectQuery("SELECT key_id, data_key FROM blob_keys").WithArgs(key).
+		WillReturnRows(sqlmock.NewRows([]string{"key_id", "data_key"}).AddRow(keyID, wrapped))
+}
+
+func readBlob(t *testing.T, s blob.Store, key string) string {
+	t.Helper()
+	rc, err := s.Open(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestSealedStore(t *testing.T) {
+	ctx := context.Background()
+	keys := testKeyring(t, "k1", "k1")
+	_, _, wrapped, err := keys.newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, mock := newMockDatabase(t, keys)
+	dir := t.TempDir()
+	local, err := blob.NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := db.SealBlobs(local)
+	if _, ok := sealed.(blob.Hasher); !ok {
+		t.Error("blob.Save would key blobs by their plain SHA-256")
+	}
+
+	const content = "attachment bytes"
+	key := strings.Repeat("a", 64)
+	expectBlobKey(mock, key, "k1", wrapped)
+	mock.ExpectExec("UPDATE blob_keys SET sealed = TRUE").WithArgs(key).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := sealed.Put(ctx, key, strings.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, key[:2], key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(raw, []byte(sealedMagic)) || bytes.Contains(raw, []byte(content)) {
+		t.Fatalf("stored blob is %q; want it sealed", raw)
+	}
+
+	expectBlobKeyLookup(mock, key, "k1", wrapped)
+	if got := readBlob(t, sealed, key); got != content {
+		t.Errorf("Open = %q; want %q", got, content)
+	}
+
+	// The blob's key is authenticated, so a sealed blob can't be passed off
+	// as another.
+	other := strings.Repeat("b", 64)
+	if err := local.Put(ctx, other, bytes.NewReader(raw), int64(len(raw))); err != nil {
+		t.Fatal(err)
+	}
+	expectBlobKeyLookup(mock, other, "k1", wrapped)
+	if _, err := sealed.Open(ctx, other); err == nil {
+		t.Error("a blob copied to another key opened")
+	}
+
+	// Blobs from before encryption are read as they are, without a lookup.
+	legacy, _, err := blob.Save(ctx, local, strings.NewReader("old and plain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readBlob(t, sealed, legacy); got != "old and plain" {
+		t.Errorf("Open(legacy) = %q; want it as it is", got)
+	}
+}
+
+// failingStore fails every Put.
+type failingStore struct{ blob.Store }
+
+func (failingStore) Put(context.Context, string, io.Reader, int64) error {
+	return errors.New("disk full")
+}
+
+// A blob is only marked sealed once it has been written.
+func TestSealedStorePutFails(t *testing.T) {
+	keys := testKeyring(t, "k1", "k1")
+	_, _, wrapped, _ := keys.newDataKey()
+	db, mock := newMockDatabase(t, keys)
+	local, _ := blob.NewLocal(t.TempDir())
+
+	key := strings.Repeat("a", 64)
+	expectBlobKey(mock, key, "k1", wrapped)
+	err := db.SealBlobs(failingStore{local}).Put(context.Background(), key, strings.NewReader("x"), 1)
+	if err == nil {
+		t.Fatal("Put succeeded")
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+	ctx := context.Background()
+	old := testKeyring(t, "old", "old")
+	_, _, wrappedOld, err := old.newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := testKeyring(t, "new", "old", "new")
+	db, mock := newMockDatabase(t, keys)
+	local, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two blobs to encrypt: one from before encryption, and one whose sealed
+	// write finished but wasn't marked as such.
+	plainKey, _, err := blob.Save(ctx, local, strings.NewReader("plain blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, wrappedBlob, _ := keys.newDataKey()
+	halfKey := strings.Repeat("c", 64)
+	expectBlobKey(mock, halfKey, "new", wrappedBlob)
+	mock.ExpectExec("UPDATE blob_keys SET sealed = TRUE").WillReturnError(errors.New("connection lost"))
+	if err := db.SealBlobs(local).Put(ctx, halfKey, strings.NewReader("half blob"), 9); err == nil {
+		t.Fatal("Put succeeded without marking the blob sealed")
+	}
+
+	// Data keys wrapped with the old key are re-wrapped.
+	var rewrapped driver.Value
+	mock.ExpectQuery("SELECT id, key_id, data_key FROM snippets").WithArgs("new", "", rotateBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "key_id", "data_key"}).AddRow("5", "old", wrappedOld))
+	mock.ExpectExec("UPDATE snippets SET key_id").WithArgs("new", recorded{&rewrapped}, "5", "old").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT blob_key, key_id, data_key FROM blob_keys").WithArgs("new", "", rotateBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"blob_key", "key_id", "data_key"}))
+
+	// A snippet from before encryption is encrypted, with its file.
+	var content, file, wrappedNew driver.Value
+	mock.ExpectQuery("SELECT id FROM snippets WHERE key_id IS NULL").WithArgs(0, rotateBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT content FROM snippets").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"content"}).AddRow("package main"))
+	mock.ExpectQuery("SELECT position, content FROM snippet_files").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"position", "content"}).AddRow(2, "a: 1"))
+	mock.ExpectExec("UPDATE snippet_files SET content").WithArgs(recorded{&file}, 7, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE snippets SET content").WithArgs(recorded{&content}, "new", recorded{&wrappedNew}, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT id FROM snippets WHERE key_id IS NULL").WithArgs(7, rotateBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	// Then the blobs, in key order.
+	first, second := plainKey, halfKey
+	if second < first {
+		first, second = second, first
+	}
+	mock.ExpectQuery("SELECT DISTINCT a.blob_key FROM attachments").WithArgs("", rotateBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"blob_key"}).AddRow(first).AddRow(second))
+	for _, key := range []string{first, second} {
+		if key == halfKey {
+			expectBlobKeyLookup(mock, key, "new", wrappedBlob)
+		}
+		expectBlobKey(mock, key, "new", wrappedBlob)
+		mock.ExpectExec("UPDATE blob_keys SET sealed = TRUE").WithArgs(key).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectQuery("SELECT DISTINCT a.blob_key FROM attachments").WithArgs(second, rotateBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"blob_key"}))
+
+	r, err := db.RotateKeys(ctx, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Rotation{SnippetsRewrapped: 1, SnippetsEncrypted: 1, BlobsEncrypted: 2}
+	if *r != want {
+		t.Errorf("RotateKeys = %+v; want %+v", *r, want)
+	}
+
+	if _, err := keys.unwrap("new", rewrapped.([]byte)); err != nil {
+		t.Errorf("the re-wrapped key doesn't unwrap with the new key: %v", err)
+	}
+	dataKey, err := keys.unwrap("new", wrappedNew.([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, err := openText(dataKey, content.(string), snippetAAD(7)); err != nil || text != "package main" {
+		t.Errorf("encrypted content opens as %q, %v", text, err)
+	}
+	if text, err := openText(dataKey, file.(string), fileAAD(7, 2)); err != nil || text != "a: 1" {
+		t.Errorf("encrypted file opens as %q, %v", text, err)
+	}
+
+	for key, want := range map[string]string{plainKey: "plain blob", halfKey: "half blob"} {
+		raw := readBlob(t, local, key)
+		if !strings.HasPrefix(raw, sealedMagic) {
+			t.Errorf("blob %s is %q; want it sealed", key[:8], raw)
+		}
+		expectBlobKeyLookup(mock, key, "new", wrappedBlob)
+		if got := readBlob(t, db.SealBlobs(local), key); got != want {
+			t.Errorf("blob %s opens as %q; want %q", key[:8], got, want)
+		}
+	}
+}
+
+func TestRotateKeysWithoutKeys(t *testing.T) {
+	if _, err := (&Database{}).RotateKeys(context.Background(), nil); err == nil {
+		t.Error("RotateKeys with no keyring succeeded")
+	}
+}
+
+func TestGetCollectionUnreadable(t *testing.T) {
+	keys := testKeyring(t, "k1", "k1")
+	dataKey, keyID, wrapped, err := keys.newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := sealText(dataKey, "echo hello", snippetAAD(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, mock := newMockDatabase(t, keys)
+	created := time.Now()
+	mock.ExpectQuery("SELECT c.id, c.user_id").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "owner", "name", "visibility", "created"}).
+			AddRow(7, 1, "alice", "Runbook", "public", created))
+	mock.ExpectQuery("FROM collection_snippets").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "created", "expires", "key_id", "data_key", "e2e"}).
+			AddRow(1, "Readable", content, created, created, keyID, wrapped, false).
+			AddRow(2, "Retired key", "ciphertext", created, created, "retired", wrapped, false))
+	mock.ExpectQuery("FROM snippet_tags").WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"snippet_id", "name"}))
+
+	c, err := db.GetCollection(context.Background(), 7)
+	if err != nil {
+		t.Fatalf("GetCollection() = %v", err)
+	}
+	if len(c.Snippets) != 2 {
+		t.Fatalf("got %d snippets; want 2", len(c.Snippets))
+	}
+	if s := c.Snippets[0]; s.Unreadable || s.Content != "echo hello" {
+		t.Errorf("readable snippet: Unreadable = %v, Content = %q", s.Unreadable, s.Content)
+	}
+	if s := c.Snippets[1]; !s.Unreadable || s.Content != "" {
+		t.Errorf("snippet with a retired key: Unreadable = %v, Content = %q", s.Unreadable, s.Content)
+	}
+}
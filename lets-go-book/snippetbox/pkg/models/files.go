@@ -0,0 +1,100 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+)
+
+// MaxFiles is how many files a snippet can have, besides its content.
+const MaxFiles = 10
+
+// Languages are the languages a file can be in. They label the file and
+// become the class of its code, as in "language-go".
+var Languages = []string{
+	"text", "go", "yaml", "json", "toml", "ini", "sql", "shell", "python", "javascript",
+	"typescript", "html", "css", "dockerfile", "makefile", "markdown",
+}
+
+// A File is one of a snippet's named files.
+type File struct {
+	Name     string
+	Language string
+	Content  string
+}
+
+// File names are kept simple so they are safe to use as they are in a zip
+// archive or a Content-Disposition header: no directories, no leading dot.
+var fileNameRE = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,99}$`)
+
+// ValidFileName reports whether name can be used for a file.
+func ValidFileName(name string) bool {
+	return fileNameRE.MatchString(name)
+}
+
+// ValidLanguage reports whether lang is one of Languages.
+func ValidLanguage(lang string) bool {
+	for _, l := range Languages {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// insertFiles adds a new snippet's files inside its transaction, encrypting
+// their content with the snippet's data key if it has one.
+func insertFiles(ctx context.Context, tx *tx, snippetID int, files []File, dataKey []byte) error {
+	for i, f := range files {
+		content := f.Content
+		if dataKey != nil {
+			var err error
+			content, err = sealText(dataKey, f.Content, fileAAD(snippetID, i))
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO snippet_files (snippet_id, position, name, language, content)
+			VALUES (?, ?, ?, ?, ?)`, snippetID, i, f.Name, f.Language, content)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadFiles fills in the snippet's Files, in order. The snippet's key is read
+// again alongside them, in case RotateKeys encrypted it in the meantime.
+func (db *Database) loadFiles(ctx context.Context, s *Snippet) error {
+	rows, err := db.QueryContext(ctx, `SELECT f.position, f.name, f.language, f.content, COALESCE(s.key_id, ''), s.data_key
+		FROM snippet_files f JOIN snippets s ON s.id = f.snippet_id
+		WHERE f.snippet_id = ? ORDER BY f.position`, s.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f File
+		var position int
+		var keyID string
+		var wrappedKey []byte
+		if err := rows.Scan(&position, &f.Name, &f.Language, &f.Content, &keyID, &wrappedKey); err != nil {
+			return err
+		}
+		if keyID != "" {
+			dataKey := s.dataKey
+			if keyID != s.keyID || !bytes.Equal(wrappedKey, s.wrappedKey) {
+				dataKey, err = db.Keys.unwrap(keyID, wrappedKey)
+				if err != nil {
+					return err
+				}
+			}
+			f.Content, err = openText(dataKey, f.Content, fileAAD(s.ID, position))
+			if err != nil {
+				return err
+			}
+		}
+		s.Files = append(s.Files, f)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+)
+
+// UserForIdentity returns the ID of the user linked to the external identity
+// (issuer, subject), for a login through single sign-on. The first time an
+// identity is seen it is linked to the user with the same email address; if
+// there isn't one, a new user is created on the spot. The caller must only
+// pass email addresses which the identity provider has verified.
+func (db *Database) UserForIdentity(ctx context.Context, issuer, subject, email, name string) (int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	var disabled bool
+	stmt := `SELECT u.id, u.disabled FROM users u
+		INNER JOIN user_identities i ON i.user_id = u.id
+		WHERE i.issuer = ? AND i.subject = ?`
+	err = tx.QueryRowContext(ctx, stmt, issuer, subject).Scan(&id, &disabled)
+	if err == sql.ErrNoRows {
+		id, disabled, err = linkIdentity(ctx, tx, issuer, subject, email, name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if disabled {
+		return 0, ErrAccountDisabled
+	}
+	return id, tx.Commit()
+}
+
+// linkIdentity links a new identity to the user with the given email,
+// creating that user first if needed.
+func linkIdentity(ctx context.Context, tx *tx, issuer, subject, email, name string) (int, bool, error) {
+	var id int
+	var disabled bool
+	row := tx.QueryRowContext(ctx, "SELECT id, disabled FROM users WHERE email = ? FOR UPDATE", email)
+	err := row.Scan(&id, &disabled)
+	if err == sql.ErrNoRows {
+		// Just-in-time provisioning. The empty password can never match, so
+		// the account can only be used through single sign-on.
+		stmt := `INSERT INTO users (name, email, password, created)
+			VALUES(?, ?, '', UTC_TIMESTAMP())`
+		result, err := tx.ExecContext(ctx, stmt, name, email)
+		if err != nil {
+			return 0, false, err
+		}
+		newID, err := result.LastInsertId()
+		if err != nil {
+			return 0, false, err
+		}
+		id = int(newID)
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	stmt := `INSERT INTO user_identities (user_id, issuer, subject, created)
+		VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	_, err = tx.ExecContext(ctx, stmt, id, issuer, subject)
+	if err != nil {
+		return 0, false, err
+	}
+	return id, disabled, nil
+}
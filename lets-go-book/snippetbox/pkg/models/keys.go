@@ -0,0 +1,228 @@
+package models
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Snippet content is encrypted at rest with envelope encryption. Each snippet
+// (and each attachment blob) has its own random data key, which encrypts its
+// content with AES-256-GCM. The data key is stored next to the content,
+// itself encrypted ("wrapped") with a key-encryption key from the Keyring,
+// along with that key's ID. Rotating the key-encryption key then only means
+// re-wrapping the data keys (see RotateKeys), not re-encrypting everything.
+//
+// Attachment blobs are content addressed (see package blob), and a plain
+// SHA-256 of the content as their key would let anyone who can read the
+// database or the bucket check whether a given file is stored. With a
+// Keyring, blobs are keyed by an HMAC of their content instead, under a
+// secret derived from the current key-encryption key. The same file is still
+// stored only once, until the key is rotated; after that it's stored once
+// more under its new name. Blobs stored before there was a Keyring keep
+// their SHA-256 names, even once RotateKeys has encrypted them.
+
+// ErrUnknownKey is returned when data was wrapped with a key-encryption key
+// that isn't in the Keyring.
+var ErrUnknownKey = errors.New("models: data was encrypted with a key that isn't configured")
+
+// A Keyring holds the key-encryption keys, by ID. New data keys are wrapped
+// with the current one; the others are kept to unwrap older data keys until
+// RotateKeys has re-wrapped them all.
+type Keyring struct {
+	keys    map[string]cipher.AEAD
+	current string
+	nameKey []byte // The HMAC key blobs are named with.
+}
+
+var keyIDRE = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,32}$`)
+
+// NewKeyring returns a keyring of AES-256 keys (32 bytes each) by ID, wrapping
+// new data keys with the current one.
+func NewKeyring(keys map[string][]byte, current string) (*Keyring, error) {
+	k := &Keyring{keys: map[string]cipher.AEAD{}, current: current}
+	for id, key := range keys {
+		if !keyIDRE.MatchString(id) {
+			return nil, fmt.Errorf("models: key ID %q must be up to 32 letters, digits, dots, hyphens or underscores", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("models: key %q is %d bytes; it must be 32", id, len(key))
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+	}
+	if k.keys[current] == nil {
+		return nil, fmt.Errorf("models: current key %q is not in the keyring", current)
+	}
+	// The key-encryption key itself is only ever used for wrapping, so the
+	// key for naming blobs is derived from it.
+	mac := hmac.New(sha256.New, keys[current])
+	mac.Write([]byte("snippetbox blob names"))
+	k.nameKey = mac.Sum(nil)
+	return k, nil
+}
+
+// LoadKeyring reads a keyring from a file with one key per line: its ID, a
+// space, then the key in base64. Blank lines and lines starting with # are
+// skipped. The last key is the current one, so to rotate, add a new key at
+// the end and run RotateKeys; the old key can be removed once that's done.
+func LoadKeyring(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := map[string][]byte{}
+	var current string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(line, " ")
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if !ok || err != nil {
+			return nil, fmt.Errorf("models: %s:%d: want a key ID and a base64 key", path, n)
+		}
+		if _, dup := keys[id]; dup {
+			return nil, fmt.Errorf("models: %s:%d: key %q is there twice", path, n, id)
+		}
+		keys[id] = key
+		current = id
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewKeyring(keys, current)
+}
+
+// Current returns the ID of the key new data keys are wrapped with.
+func (k *Keyring) Current() string {
+	return k.current
+}
+
+// blobHash returns the hash blobs are keyed by: an HMAC-SHA256 under a
+// secret, so the key doesn't tell which file it is.
+func (k *Keyring) blobHash() hash.Hash {
+	return hmac.New(sha256.New, k.nameKey)
+}
+
+// newDataKey makes a random data key and wraps it with the current key.
+func (k *Keyring) newDataKey() (dataKey []byte, keyID string, wrapped []byte, err error) {
+	dataKey = make([]byte, 32)
+	_, err = rand.Read(dataKey)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	wrapped, err = k.wrap(k.current, dataKey)
+	return dataKey, k.current, wrapped, err
+}
+
+// wrap encrypts a data key with the key-encryption key with the ID. The ID
+// is authenticated too, so a wrapped key can't be passed off as another's.
+func (k *Keyring) wrap(keyID string, dataKey []byte) ([]byte, error) {
+	aead := k.keys[keyID]
+	if aead == nil {
+		return nil, ErrUnknownKey
+	}
+	return seal(aead, dataKey, []byte(keyID))
+}
+
+// unwrap decrypts a data key wrapped with the key with the ID.
+func (k *Keyring) unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	if k == nil || k.keys[keyID] == nil {
+		return nil, ErrUnknownKey
+	}
+	return open(k.keys[keyID], wrapped, []byte(keyID))
+}
+
+// rewrap re-wraps a data key with the current key.
+func (k *Keyring) rewrap(keyID string, wrapped []byte) ([]byte, error) {
+	dataKey, err := k.unwrap(keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return k.wrap(k.current, dataKey)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a fresh random nonce, which it puts in front of
+// the ciphertext.
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// open reverses seal.
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("models: encrypted data is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
+
+// sealText encrypts text with a data key, for a text column: the result is
+// base64. additional says where the text belongs (see snippetAAD and
+// fileAAD), so that it can't be moved elsewhere along with its data key.
+func sealText(dataKey []byte, text string, additional []byte) (string, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(aead, []byte(text), additional)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openText reverses sealText.
+func openText(dataKey []byte, text string, additional []byte) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, sealed, additional)
+	return string(plaintext), err
+}
+
+// snippetAAD is the additional data a snippet's content is sealed with.
+func snippetAAD(id int) []byte {
+	return []byte(fmt.Sprintf("snippet:%d", id))
+}
+
+// fileAAD is the additional data a snippet's file is sealed with.
+func fileAAD(snippetID, position int) []byte {
+	return []byte(fmt.Sprintf("file:%d:%d", snippetID, position))
+}
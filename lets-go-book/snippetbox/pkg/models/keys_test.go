@@ -0,0 +1,208 @@
+package models
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKey returns a 32 byte key made of b, so tests can tell keys apart.
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func testKeyring(t *testing.T, current string, ids ...string) *Keyring {
+	t.Helper()
+	keys := map[string][]byte{}
+	for i, id := range ids {
+		keys[id] = testKey(byte('a' + i))
+	}
+	k, err := NewKeyring(keys, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func writeKeyFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadKeyring(t *testing.T) {
+	a := base64.StdEncoding.EncodeToString(testKey('a'))
+	b := base64.StdEncoding.EncodeToString(testKey('b'))
+	k, err := LoadKeyring(writeKeyFile(t, "# Old first, current last.\n\n2024-01 "+a+"\n  2025-06 "+b+"  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Current() != "2025-06" {
+		t.Errorf("Current() = %q; want the last key, 2025-06", k.Current())
+	}
+	if len(k.keys) != 2 || k.keys["2024-01"] == nil {
+		t.Errorf("keyring has %d keys; want both", len(k.keys))
+	}
+
+	tests := []struct {
+		name, content, want string
+	}{
+		{"empty", "# nothing\n", "not in the keyring"},
+		{"no key", "2024-01\n", "want a key ID and a base64 key"},
+		{"bad base64", "2024-01 !!!\n", "want a key ID and a base64 key"},
+		{"short key", "2024-01 " + base64.StdEncoding.EncodeToString([]byte("short")) + "\n", "it must be 32"},
+		{"bad ID", "a/b " + a + "\n", "must be up to 32 letters"},
+		{"duplicate", "2024-01 " + a + "\n2024-01 " + b + "\n", "is there twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadKeyring(writeKeyFile(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadKeyring error = %v; want it to contain %q", err, tt.want)
+			}
+		})
+	}
+	if _, err := LoadKeyring(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("LoadKeyring of a missing file succeeded")
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	aead, err := newGCM(testKey('a'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := seal(aead, []byte("secret"), []byte("here"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := seal(aead, []byte("secret"), []byte("here"))
+	if bytes.Equal(sealed, again) {
+		t.Error("sealing twice gave the same result; the nonce should be random")
+	}
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Error("the sealed data contains the plaintext")
+	}
+
+	plaintext, err := open(aead, sealed, []byte("here"))
+	if err != nil || string(plaintext) != "secret" {
+		t.Fatalf("open = %q, %v; want the plaintext", plaintext, err)
+	}
+	if _, err := open(aead, sealed, []byte("there")); err == nil {
+		t.Error("open with different additional data succeeded")
+	}
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := open(aead, tampered, []byte("here")); err == nil {
+		t.Error("open of tampered data succeeded")
+	}
+	if _, err := open(aead, sealed[:4], nil); err == nil {
+		t.Error("open of truncated data succeeded")
+	}
+	other, _ := newGCM(testKey('b'))
+	if _, err := open(other, sealed, []byte("here")); err == nil {
+		t.Error("open with the wrong key succeeded")
+	}
+}
+
+func TestSealText(t *testing.T) {
+	dataKey := testKey('d')
+	sealed, err := sealText(dataKey, "package main", snippetAAD(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := base64.StdEncoding.DecodeString(sealed); err != nil {
+		t.Errorf("sealText gave %q, which isn't base64", sealed)
+	}
+	text, err := openText(dataKey, sealed, snippetAAD(1))
+	if err != nil || text != "package main" {
+		t.Fatalf("openText = %q, %v; want the text back", text, err)
+	}
+
+	// The content can't be moved to another snippet, or to a file.
+	for _, aad := range [][]byte{snippetAAD(2), fileAAD(1, 0), nil} {
+		if _, err := openText(dataKey, sealed, aad); err == nil {
+			t.Errorf("content sealed for snippet 1 opened as %q", aad)
+		}
+	}
+	file, _ := sealText(dataKey, "a: 1", fileAAD(1, 0))
+	if _, err := openText(dataKey, file, fileAAD(1, 1)); err == nil {
+		t.Error("file 0 opened as file 1")
+	}
+	if _, err := openText(dataKey, "not base64!", snippetAAD(1)); err == nil {
+		t.Error("openText of something that isn't base64 succeeded")
+	}
+}
+
+func TestWrapUnwrap(t *testing.T) {
+	k := testKeyring(t, "old", "old")
+	dataKey, keyID, wrapped, err := k.newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != "old" || len(dataKey) != 32 || bytes.Contains(wrapped, dataKey) {
+		t.Fatalf("newDataKey = %d bytes wrapped with %q", len(dataKey), keyID)
+	}
+	got, err := k.unwrap("old", wrapped)
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("unwrap = %x, %v; want the data key", got, err)
+	}
+
+	// Add a new current key: the old data key still unwraps, and rewraps
+	// with the new one.
+	k = testKeyring(t, "new", "old", "new")
+	rewrapped, err := k.rewrap("old", wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = k.unwrap("new", rewrapped)
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("unwrap after rewrap = %x, %v; want the data key", got, err)
+	}
+	// The key ID is authenticated, so a wrapped key can't claim another.
+	if _, err := k.unwrap("old", rewrapped); err == nil {
+		t.Error("a key wrapped with new unwrapped as old")
+	}
+
+	// Once the old key is retired, what it wrapped can't be read.
+	k = testKeyring(t, "new", "new")
+	if _, err := k.unwrap("old", wrapped); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unwrap with a retired key = %v; want ErrUnknownKey", err)
+	}
+	var none *Keyring
+	if _, err := none.unwrap("old", wrapped); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unwrap with no keyring = %v; want ErrUnknownKey", err)
+	}
+}
+
+func TestBlobHash(t *testing.T) {
+	sum := func(k *Keyring, content string) string {
+		h := k.blobHash()
+		h.Write([]byte(content))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	k := testKeyring(t, "k1", "k1")
+	key := sum(k, "config")
+	plain := sha256.Sum256([]byte("config"))
+	if key == hex.EncodeToString(plain[:]) {
+		t.Error("blob key is the plain SHA-256 of the content")
+	}
+	if again := sum(testKeyring(t, "k1", "k1"), "config"); again != key {
+		t.Errorf("the same keyring keyed the same content %q, then %q", key, again)
+	}
+	if other := sum(k, "other config"); other == key {
+		t.Error("different content got the same key")
+	}
+	if rotated := sum(testKeyring(t, "k2", "k1", "k2"), "config"); rotated == key {
+		t.Error("a different current key gave the same blob key")
+	}
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"encoding/base64"
+	"strings"
 	"time"
 )
 
@@ -11,7 +13,155 @@ type Snippet struct {
 	Content string
 	Created time.Time
 	Expires time.Time
+	Tags    []string // Normalized tag names, in alphabetical order.
+	Format  Format   // Only set by GetSnippet; the zero value shows as code.
+
+	// These are only set by GetSnippet.
+	Files       []File
+	Attachments []Attachment
+	UserID      int // Who created it; 0 for snippets from before we kept track.
+	ParentID    int // The snippet it was forked from, or 0.
+	Forks       int // How many live snippets were forked from it.
+
+	Stars int // Set by GetSnippet and the listings in stars.go.
+
+	// E2E marks an end-to-end encrypted snippet, whose Content is ciphertext
+	// only the browser can decrypt (see ValidCiphertext). Set by GetSnippet
+	// and GetCollection.
+	E2E bool
+
+	// Unreadable marks a snippet whose content couldn't be decrypted, for
+	// instance because its key has been retired. GetCollection shows the
+	// others rather than failing; its Content is empty.
+	Unreadable bool
+
+	// How the content is encrypted at rest (see keys.go): the ID of the key
+	// its data key is wrapped with, or "" if it isn't, and the wrapped data
+	// key. dataKey is the unwrapped one, once the content has been decrypted.
+	keyID      string
+	wrappedKey []byte
+	dataKey    []byte
+}
+
+// Format says how a snippet's content is shown.
+type Format string
+
+const (
+	FormatPlain    Format = "plain"    // Wrapped text.
+	FormatCode     Format = "code"     // Verbatim, with line numbers.
+	FormatMarkdown Format = "markdown" // Rendered to (sanitized) HTML.
+)
+
+// Valid reports whether f is one of the known formats.
+func (f Format) Valid() bool {
+	return f == FormatPlain || f == FormatCode || f == FormatMarkdown
+}
+
+// A Line is one line of a snippet's content, numbered from 1.
+type Line struct {
+	Number int
+	Text   string
+}
+
+// Lines splits the content into numbered lines, for showing line numbers and
+// anchoring comments to them. An end-to-end encrypted snippet has none that
+// the server can see.
+func (s *Snippet) Lines() []Line {
+	if s.E2E {
+		return nil
+	}
+	text := strings.TrimSuffix(strings.ReplaceAll(s.Content, "\r\n", "\n"), "\n")
+	var lines []Line
+	for i, line := range strings.Split(text, "\n") {
+		lines = append(lines, Line{Number: i + 1, Text: line})
+	}
+	return lines
+}
+
+// Line returns the text of line n, or "" if there's no such line.
+func (s *Snippet) Line(n int) string {
+	lines := s.Lines()
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return lines[n-1].Text
+}
+
+// ValidCiphertext reports whether content could be an end-to-end encrypted
+// snippet: the base64 of a 12 byte AES-GCM nonce, then the ciphertext with
+// its 16 byte tag.
+func ValidCiphertext(content string) bool {
+	b, err := base64.StdEncoding.DecodeString(content)
+	return err == nil && len(b) >= 12+16
 }
 
 // For convenience we also define a Snippets type, which is a slice for holding // multiple Snippet objects.
 type Snippets []*Snippet
+
+// A Role decides what a user is allowed to do. Roles are ordered, so a
+// moderator can do everything a user can, and an admin everything a moderator
+// can.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+var roleRank = map[Role]int{RoleUser: 1, RoleModerator: 2, RoleAdmin: 3}
+
+// Valid reports whether r is one of the known roles.
+func (r Role) Valid() bool {
+	return roleRank[r] > 0
+}
+
+// AtLeast reports whether r grants at least the permissions of min.
+func (r Role) AtLeast(min Role) bool {
+	return r.Valid() && roleRank[r] >= roleRank[min]
+}
+
+// Define a User type to hold the information about an individual user. The
+// hashed password is deliberately left out.
+type User struct {
+	ID       int
+	Name     string
+	Email    string
+	Role     Role
+	Disabled bool
+	Locale   string // The user's chosen language tag, or "" for none.
+	TimeZone string // The user's chosen IANA time zone, or "" for none.
+	Created  time.Time
+}
+
+// IsModerator and IsAdmin are small conveniences for the templates, which
+// can't easily compare a Role against a string constant.
+func (u *User) IsModerator() bool { return u.Role.AtLeast(RoleModerator) }
+func (u *User) IsAdmin() bool     { return u.Role.AtLeast(RoleAdmin) }
+
+type Users []*User
+
+// A Tag is a tag with the number of (live) snippets that have it. Weight
+// ranks it from 1 to 5 against the other tags in a tag cloud.
+type Tag struct {
+	Name   string
+	Count  int
+	Weight int
+}
+
+type Tags []*Tag
+
+// An AuditEntry records a single moderation action: who did what to which
+// user or snippet, and when.
+type AuditEntry struct {
+	ID         int
+	ActorID    int
+	ActorName  string
+	Action     string
+	TargetType string
+	TargetID   int
+	Detail     string
+	Created    time.Time
+}
+
+type AuditLog []*AuditEntry
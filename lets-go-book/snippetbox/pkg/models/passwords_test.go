@@ -0,0 +1,85 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+// Cheap parameters, so the tests run quickly.
+var (
+	testBcrypt = &PasswordHasher{Algorithm: Bcrypt, BcryptCost: 4}
+	testArgon2 = &PasswordHasher{Algorithm: Argon2id, Argon2: Argon2Params{Memory: 64, Time: 1, Threads: 1}}
+)
+
+func TestPasswordHasher(t *testing.T) {
+	for _, h := range []*PasswordHasher{testBcrypt, testArgon2} {
+		t.Run(h.Algorithm, func(t *testing.T) {
+			hash, err := h.Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			match, rehash, err := h.Verify(hash, "correct horse")
+			if err != nil || !match || rehash {
+				t.Errorf("Verify(right password) = %v, %v, %v; want true, false, nil", match, rehash, err)
+			}
+			match, _, err = h.Verify(hash, "battery staple")
+			if err != nil || match {
+				t.Errorf("Verify(wrong password) = %v, %v; want false, nil", match, err)
+			}
+		})
+	}
+}
+
+func TestPasswordHasherRehash(t *testing.T) {
+	stronger := &PasswordHasher{Algorithm: Bcrypt, BcryptCost: 5}
+	moreMemory := &PasswordHasher{Algorithm: Argon2id, Argon2: Argon2Params{Memory: 128, Time: 1, Threads: 1}}
+	tests := []struct {
+		name         string
+		hashed, now  *PasswordHasher
+		wantRehashed bool
+	}{
+		{"same bcrypt cost", testBcrypt, testBcrypt, false},
+		{"higher bcrypt cost", testBcrypt, stronger, true},
+		{"lower bcrypt cost", stronger, testBcrypt, false},
+		{"bcrypt to argon2id", testBcrypt, testArgon2, true},
+		{"argon2id to bcrypt", testArgon2, testBcrypt, true},
+		{"different argon2 params", testArgon2, moreMemory, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := tt.hashed.Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			match, rehash, err := tt.now.Verify(hash, "correct horse")
+			if err != nil || !match {
+				t.Fatalf("Verify = %v, %v; want a match", match, err)
+			}
+			if rehash != tt.wantRehashed {
+				t.Errorf("rehash = %v; want %v", rehash, tt.wantRehashed)
+			}
+		})
+	}
+}
+
+func TestPasswordHasherErrors(t *testing.T) {
+	if _, err := (&PasswordHasher{Algorithm: "md5"}).Hash("x"); err == nil {
+		t.Error("Hash with an unknown algorithm succeeded")
+	}
+	if _, err := testBcrypt.Hash(strings.Repeat("é", BcryptMaxBytes/2+1)); err == nil {
+		t.Errorf("bcrypt hashed a password over %d bytes", BcryptMaxBytes)
+	}
+	for _, hash := range []string{"", "$argon2id$v=19$m=64", "$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5"} {
+		if _, _, err := testArgon2.Verify(hash, "x"); err == nil {
+			t.Errorf("Verify(%q) succeeded", hash)
+		}
+	}
+}
+
+// The zero value hashes with bcrypt at cost 12, as InsertUser always did.
+func TestPasswordHasherZeroValue(t *testing.T) {
+	var h *PasswordHasher
+	if h.algorithm() != Bcrypt || h.bcryptCost() != 12 {
+		t.Errorf("zero hasher uses %s at cost %d; want bcrypt at 12", h.algorithm(), h.bcryptCost())
+	}
+}
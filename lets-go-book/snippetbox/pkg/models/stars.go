@@ -0,0 +1,110 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// ToggleStar stars a snippet for a user, or unstars it if they had already
+// starred it, and reports whether it is now starred. Only live snippets can
+// be starred; it returns ErrNoRecord for any other.
+func (db *Database) ToggleStar(ctx context.Context, userID, snippetID int) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	stmt := `INSERT IGNORE INTO stars (user_id, snippet_id, created)
+		SELECT ?, id, UTC_TIMESTAMP() FROM snippets
+		WHERE id = ? AND expires > UTC_TIMESTAMP() AND removed IS NULL`
+	result, err := tx.ExecContext(ctx, stmt, userID, snippetID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	starred, delta := true, 1
+	if n == 0 {
+		// Either it was already starred, or there's no such snippet.
+		result, err = tx.ExecContext(ctx, `DELETE FROM stars WHERE user_id = ? AND snippet_id = ?`,
+			userID, snippetID)
+		if err != nil {
+			return false, err
+		}
+		n, err = result.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+		if n == 0 {
+			return false, ErrNoRecord
+		}
+		starred, delta = false, -1
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE snippets SET stars = stars + ? WHERE id = ?`, delta, snippetID)
+	if err != nil {
+		return false, err
+	}
+	return starred, tx.Commit()
+}
+
+// HasStarred reports whether the user has starred the snippet.
+func (db *Database) HasStarred(ctx context.Context, userID, snippetID int) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	var starred bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM stars WHERE user_id = ? AND snippet_id = ?)`,
+		userID, snippetID).Scan(&starred)
+	return starred, err
+}
+
+// StarredSnippets returns the live snippets a user has starred, most recently
+// starred first.
+func (db *Database) StarredSnippets(ctx context.Context, userID int) (Snippets, error) {
+	return db.listStarred(ctx, `SELECT s.id, s.title, s.created, s.expires, s.stars
+		FROM stars st JOIN snippets s ON s.id = st.snippet_id
+		WHERE st.user_id = ? AND s.expires > UTC_TIMESTAMP() AND s.removed IS NULL
+		ORDER BY st.created DESC LIMIT 100`, userID)
+}
+
+// PopularSnippets returns the ten live snippets starred most in the window
+// up to now. Only the stars given in the window are read (through
+// idx_stars_created), so the cost grows with recent activity rather than
+// with the size of the tables.
+func (db *Database) PopularSnippets(ctx context.Context, window time.Duration) (Snippets, error) {
+	since := time.Now().UTC().Add(-window)
+	return db.listStarred(ctx, `SELECT s.id, s.title, s.created, s.expires, s.stars
+		FROM (SELECT snippet_id, COUNT(*) AS n FROM stars WHERE created > ? GROUP BY snippet_id) recent
+		JOIN snippets s ON s.id = recent.snippet_id
+		WHERE s.expires > UTC_TIMESTAMP() AND s.removed IS NULL
+		ORDER BY recent.n DESC, s.stars DESC, s.created DESC LIMIT 10`, since)
+}
+
+func (db *Database) listStarred(ctx context.Context, stmt string, args ...interface{}) (Snippets, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snippets := Snippets{}
+	for rows.Next() {
+		s := &Snippet{}
+		err := rows.Scan(&s.ID, &s.Title, &s.Created, &s.Expires, &s.Stars)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return snippets, db.loadTags(ctx, snippets...)
+}
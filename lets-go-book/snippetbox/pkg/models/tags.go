@@ -0,0 +1,153 @@
+package models
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Limits on tags. MaxTagLength matches the tags.name column.
+const (
+	MaxTags      = 10
+	MaxTagLength = 32
+)
+
+var rxTag = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// NormalizeTag turns what someone typed into the tag's canonical form: lower
+// case, with runs of spaces replaced by a hyphen. "Billing API" becomes
+// "billing-api".
+func NormalizeTag(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), "-")
+}
+
+// ValidTag reports whether tag, which should already be normalized, is one
+// we'll store: letters, digits, dots, hyphens and underscores, starting with
+// a letter or digit, and no longer than MaxTagLength.
+func ValidTag(tag string) bool {
+	return len(tag) <= MaxTagLength && rxTag.MatchString(tag)
+}
+
+// insertTags tags the snippet, creating any tags that don't exist yet.
+func insertTags(ctx context.Context, tx *tx, snippetID int, tags []string) error {
+	for _, tag := range tags {
+		// LAST_INSERT_ID(id) makes LastInsertId return the existing tag's ID
+		// when the name is already taken.
+		result, err := tx.ExecContext(ctx, `INSERT INTO tags (name) VALUES (?)
+			ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)`, tag)
+		if err != nil {
+			return err
+		}
+		tagID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT IGNORE INTO snippet_tags (snippet_id, tag_id) VALUES (?, ?)`,
+			snippetID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTags fills in the Tags of each snippet, with a single query.
+func (db *Database) loadTags(ctx context.Context, snippets ...*Snippet) error {
+	if len(snippets) == 0 {
+		return nil
+	}
+	byID := make(map[int]*Snippet, len(snippets))
+	args := make([]interface{}, len(snippets))
+	for i, s := range snippets {
+		byID[s.ID] = s
+		args[i] = s.ID
+	}
+	stmt := `SELECT st.snippet_id, t.name FROM snippet_tags st JOIN tags t ON t.id = st.tag_id
+		WHERE st.snippet_id IN (?` + strings.Repeat(", ?", len(snippets)-1) + `)
+		ORDER BY t.name`
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		byID[id].Tags = append(byID[id].Tags, name)
+	}
+	return rows.Err()
+}
+
+// TaggedSnippets returns the latest live snippets with the given tag.
+func (db *Database) TaggedSnippets(ctx context.Context, tag string) (Snippets, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	stmt := `SELECT s.id, s.title, s.created, s.expires FROM snippets s
+		JOIN snippet_tags st ON st.snippet_id = s.id JOIN tags t ON t.id = st.tag_id
+		WHERE t.name = ? AND s.expires > UTC_TIMESTAMP() AND s.removed IS NULL
+		ORDER BY s.created DESC LIMIT 50`
+	rows, err := db.QueryContext(ctx, stmt, tag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snippets := Snippets{}
+	for rows.Next() {
+		s := &Snippet{}
+		err := rows.Scan(&s.ID, &s.Title, &s.Created, &s.Expires)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return snippets, db.loadTags(ctx, snippets...)
+}
+
+// TagCloud returns the (at most limit) most used tags among live snippets,
+// in alphabetical order, each with a Weight from 1 to 5 for the cloud.
+func (db *Database) TagCloud(ctx context.Context, limit int) (Tags, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	stmt := `SELECT name, n FROM (
+			SELECT t.name, COUNT(*) AS n FROM tags t
+			JOIN snippet_tags st ON st.tag_id = t.id JOIN snippets s ON s.id = st.snippet_id
+			WHERE s.expires > UTC_TIMESTAMP() AND s.removed IS NULL
+			GROUP BY t.id, t.name ORDER BY n DESC, t.name LIMIT ?
+		) top ORDER BY name`
+	rows, err := db.QueryContext(ctx, stmt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := Tags{}
+	max := 1
+	for rows.Next() {
+		t := &Tag{}
+		if err := rows.Scan(&t.Name, &t.Count); err != nil {
+			return nil, err
+		}
+		if t.Count > max {
+			max = t.Count
+		}
+		tags = append(tags, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	// Weigh the counts on a log scale, so one very popular tag doesn't make
+	// all the others look the same.
+	for _, t := range tags {
+		t.Weight = 1
+		if max > 1 {
+			t.Weight += int(math.Round(4 * math.Log(float64(t.Count)) / math.Log(float64(max))))
+		}
+	}
+	return tags, nil
+}
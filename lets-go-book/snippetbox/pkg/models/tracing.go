@@ -0,0 +1,122 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/noelruault/lets-go/snippetbox/pkg/models")
+
+// QueryContext, QueryRowContext and ExecContext shadow the methods of the
+// embedded sql.DB so that every query the models run gets its own span. All
+// three execute the statement before returning, so the span covers the time
+// spent in the database.
+func (db *Database) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	endSpan(span, err)
+	return rows, err
+}
+
+func (db *Database) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := startQuerySpan(ctx, query)
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+	return row
+}
+
+func (db *Database) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+	return result, err
+}
+
+// tx is a transaction whose statements are traced like the ones above, as
+// children of a span covering the whole transaction.
+type tx struct {
+	*sql.Tx
+	ctx  context.Context
+	span trace.Span
+}
+
+func (db *Database) begin(ctx context.Context) (*tx, error) {
+	ctx, span := tracer.Start(ctx, "transaction", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "mysql")))
+	sqlTx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tx{Tx: sqlTx, ctx: ctx, span: span}, nil
+}
+
+func (tx *tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	_, span := startQuerySpan(tx.ctx, query)
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	endSpan(span, err)
+	return rows, err
+}
+
+func (tx *tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	_, span := startQuerySpan(tx.ctx, query)
+	row := tx.Tx.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+	return row
+}
+
+func (tx *tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	_, span := startQuerySpan(tx.ctx, query)
+	result, err := tx.Tx.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+	return result, err
+}
+
+func (tx *tx) Commit() error {
+	err := tx.Tx.Commit()
+	endSpan(tx.span, err)
+	return err
+}
+
+// Rollback ends the transaction's span unless Commit already has, so it's
+// still fine to defer it.
+func (tx *tx) Rollback() error {
+	err := tx.Tx.Rollback()
+	if tx.span.IsRecording() {
+		tx.span.SetAttributes(attribute.Bool("db.rollback", true))
+		tx.span.End()
+	}
+	return err
+}
+
+// startQuerySpan names the span after the SQL operation ("SELECT", "UPDATE"...)
+// and records the statement itself. Statements only ever contain placeholders,
+// never values, so they're safe to export.
+func startQuerySpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	query = strings.Join(strings.Fields(query), " ")
+	operation := query
+	if i := strings.IndexByte(query, ' '); i > 0 {
+		operation = query[:i]
+	}
+	return tracer.Start(ctx, operation, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mysql"),
+			attribute.String("db.statement", query),
+		))
+}
+
+// endSpan ends span, marking it as failed if err is a real error. Not finding
+// a row is an expected outcome, not a failure.
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
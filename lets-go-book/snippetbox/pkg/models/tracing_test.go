@@ -0,0 +1,60 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraceTransaction(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(tp)
+
+	db, mock := newMockDatabase(t, nil)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT position").WillReturnRows(sqlmock.NewRows([]string{"position"}).AddRow(0))
+	mock.ExpectQuery("SELECT id").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("UPDATE snippets").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	ctx := context.Background()
+	tx, err := db.begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT position FROM snippet_files WHERE snippet_id = ?`, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	var id int
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM snippets WHERE id = ?`, 1).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE snippets SET title = ? WHERE id = ?`, "t", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans; want 4", len(spans))
+	}
+	// The transaction's span ends last, and every statement is its child.
+	transaction := spans[3]
+	if transaction.Name != "transaction" {
+		t.Errorf("last span = %q; want the transaction", transaction.Name)
+	}
+	for i, want := range []string{"SELECT", "SELECT", "UPDATE"} {
+		if spans[i].Name != want || spans[i].Parent.SpanID() != transaction.SpanContext.SpanID() {
+			t.Errorf("span %d = %q, parent %s; want %q in the transaction", i, spans[i].Name, spans[i].Parent.SpanID(), want)
+		}
+	}
+}
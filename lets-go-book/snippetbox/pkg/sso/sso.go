@@ -0,0 +1,127 @@
+// Package sso implements OpenID Connect single sign-on using the
+// authorization code flow with PKCE.
+package sso
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrDomainNotAllowed = errors.New("sso: email domain is not allowed")
+	ErrEmailNotVerified = errors.New("sso: email address is not verified")
+)
+
+// Config holds the settings needed to talk to an OpenID provider.
+type Config struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// AllowedDomain, if set, restricts logins to email addresses at that
+	// domain (e.g. "example.com").
+	AllowedDomain string
+}
+
+// Identity is what we learn about a user from their ID token.
+type Identity struct {
+	Issuer  string
+	Subject string
+	Email   string
+	Name    string
+}
+
+// A Provider starts logins and completes them once the user comes back.
+type Provider struct {
+	config        oauth2.Config
+	verifier      *oidc.IDTokenVerifier
+	allowedDomain string
+}
+
+// New fetches the provider's discovery document and returns a Provider ready
+// to use. The discovery request is bound to ctx.
+func New(ctx context.Context, c Config) (*Provider, error) {
+	provider, err := oidc.NewProvider(ctx, c.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{
+		config: oauth2.Config{
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			RedirectURL:  c.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		verifier:      provider.Verifier(&oidc.Config{ClientID: c.ClientID}),
+		allowedDomain: strings.ToLower(c.AllowedDomain),
+	}, nil
+}
+
+// NewState returns the three random values a login needs: the state and nonce
+// echoed back by the provider, and the PKCE code verifier. All of them must
+// be kept (in the session) until the callback.
+func NewState() (state, nonce, verifier string) {
+	return oauth2.GenerateVerifier(), oauth2.GenerateVerifier(), oauth2.GenerateVerifier()
+}
+
+// AuthCodeURL returns the provider URL to send the user to.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	return p.config.AuthCodeURL(state, oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchange trades the code from the callback for an ID token, checks it, and
+// returns the identity it describes.
+func (p *Provider) Exchange(ctx context.Context, code, nonce, verifier string) (*Identity, error) {
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("sso: token response has no id_token")
+	}
+	idToken, err := p.verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("sso: id_token nonce does not match")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	// We link identities to existing accounts by email, so an address the
+	// provider hasn't verified can't be trusted.
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+	local, domain := claims.Email, ""
+	if at := strings.LastIndex(claims.Email, "@"); at >= 0 {
+		local, domain = claims.Email[:at], claims.Email[at+1:]
+	}
+	if p.allowedDomain != "" && strings.ToLower(domain) != p.allowedDomain {
+		return nil, ErrDomainNotAllowed
+	}
+	if claims.Name == "" {
+		claims.Name = local
+	}
+
+	return &Identity{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+		Email:   claims.Email,
+		Name:    claims.Name,
+	}, nil
+}
@@ -0,0 +1,110 @@
+package sso_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/noelruault/lets-go/snippetbox/pkg/sso"
+	"github.com/noelruault/lets-go/snippetbox/pkg/sso/ssotest"
+)
+
+// login runs the whole authorization code flow against the stand-in provider
+// and returns the result of the exchange.
+func login(t *testing.T, idp *ssotest.Provider, allowedDomain string) (*sso.Identity, error) {
+	t.Helper()
+	ctx := context.Background()
+	p, err := sso.New(ctx, sso.Config{
+		IssuerURL:     idp.URL,
+		ClientID:      idp.ClientID,
+		RedirectURL:   "https://snippetbox.test/user/login/sso/callback",
+		AllowedDomain: allowedDomain,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, nonce, verifier := sso.NewState()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(p.AuthCodeURL(state, nonce, verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := callback.Query().Get("state"); got != state {
+		t.Fatalf("state = %q; want %q", got, state)
+	}
+	return p.Exchange(ctx, callback.Query().Get("code"), nonce, verifier)
+}
+
+func TestExchange(t *testing.T) {
+	idp := ssotest.NewProvider("snippetbox")
+	defer idp.Close()
+	idp.User = ssotest.User{Subject: "42", Email: "alice@example.com", EmailVerified: true}
+
+	id, err := login(t, idp, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sso.Identity{Issuer: idp.URL, Subject: "42", Email: "alice@example.com", Name: "alice"}
+	if *id != want {
+		t.Errorf("identity = %+v; want %+v", *id, want)
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   ssotest.User
+		domain string
+		want   error
+	}{
+		{"unverified", ssotest.User{Subject: "1", Email: "bob@example.com"}, "", sso.ErrEmailNotVerified},
+		{"wrong domain", ssotest.User{Subject: "2", Email: "eve@evil.test", EmailVerified: true}, "example.com", sso.ErrDomainNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := ssotest.NewProvider("snippetbox")
+			defer idp.Close()
+			idp.User = tt.user
+			if _, err := login(t, idp, tt.domain); err != tt.want {
+				t.Errorf("err = %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	idp := ssotest.NewProvider("snippetbox")
+	defer idp.Close()
+	idp.User = ssotest.User{Subject: "42", Email: "alice@example.com", EmailVerified: true}
+
+	ctx := context.Background()
+	p, err := sso.New(ctx, sso.Config{IssuerURL: idp.URL, ClientID: "snippetbox",
+		RedirectURL: "https://snippetbox.test/cb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, nonce, verifier := sso.NewState()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(p.AuthCodeURL(state, nonce, verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+
+	_, _, other := sso.NewState()
+	if _, err := p.Exchange(ctx, callback.Query().Get("code"), nonce, other); err == nil {
+		t.Error("exchange with the wrong PKCE verifier succeeded")
+	}
+}
@@ -0,0 +1,11 @@
+// Package ui holds the HTML templates and static assets, embedded in the
+// binary so that it can run without a ./ui directory next to it.
+package ui
+
+import "embed"
+
+// Files contains the html and static directories. The tarball in static is
+// left out on purpose; it isn't served.
+//
+//go:embed html static/css static/img static/js
+var Files embed.FS
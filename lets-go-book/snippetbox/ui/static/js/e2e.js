@@ -0,0 +1,179 @@
+// End-to-end encrypted snippets. The browser encrypts the content with
+// AES-256-GCM before the form is sent, under a random key that only ever
+// lives in the URL fragment (the part after #), which browsers don't send to
+// the server. The server stores the ciphertext, base64(nonce || ciphertext),
+// and the show page decrypts it here with the key from the fragment.
+(function () {
+    "use strict";
+
+    function toBase64(bytes) {
+        var s = "";
+        for (var i = 0; i < bytes.length; i++) {
+            s += String.fromCharCode(bytes[i]);
+        }
+        return btoa(s);
+    }
+
+    function fromBase64(s) {
+        var raw = atob(s);
+        var bytes = new Uint8Array(raw.length);
+        for (var i = 0; i < raw.length; i++) {
+            bytes[i] = raw.charCodeAt(i);
+        }
+        return bytes;
+    }
+
+    // The key goes in the fragment as URL-safe base64, without padding.
+    function keyToFragment(bytes) {
+        return toBase64(bytes).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+    }
+
+    function keyFromFragment() {
+        var s = location.hash.slice(1).replace(/-/g, "+").replace(/_/g, "/");
+        if (!/^[A-Za-z0-9+\/]{43}$/.test(s)) {
+            return null;
+        }
+        return fromBase64(s + "=");
+    }
+
+    function importKey(bytes) {
+        return crypto.subtle.importKey("raw", bytes, "AES-GCM", false, ["encrypt", "decrypt"]);
+    }
+
+    function encrypt(keyBytes, text) {
+        var nonce = crypto.getRandomValues(new Uint8Array(12));
+        return importKey(keyBytes).then(function (key) {
+            return crypto.subtle.encrypt({ name: "AES-GCM", iv: nonce }, key, new TextEncoder().encode(text));
+        }).then(function (ciphertext) {
+            var sealed = new Uint8Array(nonce.length + ciphertext.byteLength);
+            sealed.set(nonce);
+            sealed.set(new Uint8Array(ciphertext), nonce.length);
+            return toBase64(sealed);
+        });
+    }
+
+    function decrypt(keyBytes, content) {
+        var sealed = fromBase64(content);
+        return importKey(keyBytes).then(function (key) {
+            return crypto.subtle.decrypt({ name: "AES-GCM", iv: sealed.slice(0, 12) }, key, sealed.slice(12));
+        }).then(function (plaintext) {
+            return new TextDecoder().decode(plaintext);
+        });
+    }
+
+    // The new snippet form. The option stays hidden without JavaScript (or
+    // WebCrypto, which needs HTTPS), since then we couldn't encrypt anything.
+    function setUpForm(option) {
+        var form = option.form;
+        var box = form.elements.e2e;
+        var content = form.elements.content;
+        var unsupported = form.querySelectorAll("[data-e2e-unsupported]");
+        var markdown = form.querySelector("input[name=format][value=markdown]");
+
+        function toggle() {
+            for (var i = 0; i < unsupported.length; i++) {
+                unsupported[i].hidden = box.checked;
+            }
+            if (box.checked && markdown.checked) {
+                form.querySelector("input[name=format][value=code]").checked = true;
+            }
+            markdown.disabled = box.checked;
+        }
+        box.addEventListener("change", toggle);
+        option.hidden = false;
+
+        // The form came back with errors: the content is still the ciphertext
+        // we sent, so decrypt it again for editing.
+        if (box.checked) {
+            var key = keyFromFragment();
+            var restore = key ? decrypt(key, content.value) : Promise.reject();
+            restore.then(function (text) {
+                content.value = text;
+            }, function () {
+                content.value = "";
+            });
+        }
+        toggle();
+
+        var sending = false;
+        form.addEventListener("submit", function (e) {
+            // Adding a file slot isn't publishing; that button is hidden for
+            // E2E snippets anyway.
+            if (!box.checked || sending || (e.submitter && e.submitter.name === "add_file")) {
+                return;
+            }
+            e.preventDefault();
+            var key = crypto.getRandomValues(new Uint8Array(32));
+            encrypt(key, content.value).then(function (ciphertext) {
+                content.value = ciphertext;
+                content.readOnly = true;
+                // The fragment is kept when the server redirects us to the
+                // new snippet, so it ends up in the address to share.
+                form.action = "/snippet/new#" + keyToFragment(key);
+                sending = true;
+                form.submit();
+            });
+        });
+    }
+
+    // Every form on an end-to-end encrypted snippet's pages (comments, stars,
+    // collections...) comes back to it. Giving each the key in its address
+    // keeps it there: the fragment survives the redirect back, or the form
+    // being shown again with errors. Links marked data-e2e-link, such as a
+    // comment's edit link, keep it likewise.
+    function carryKey() {
+        if (!keyFromFragment()) {
+            return;
+        }
+        var forms = document.querySelectorAll("form[action]");
+        for (var i = 0; i < forms.length; i++) {
+            var action = forms[i].getAttribute("action");
+            if (action.indexOf("#") < 0) {
+                forms[i].setAttribute("action", action + location.hash);
+            }
+        }
+        var links = document.querySelectorAll("a[data-e2e-link]");
+        for (var j = 0; j < links.length; j++) {
+            var href = links[j].getAttribute("href");
+            if (href.indexOf("#") < 0) {
+                links[j].setAttribute("href", href + location.hash);
+            }
+        }
+    }
+
+    // The show page.
+    function showSnippet(el) {
+        var target = el.querySelector("[data-e2e-target]");
+        var status = el.querySelector("[data-e2e-status]");
+        var key = keyFromFragment();
+        if (!key) {
+            status.textContent = el.dataset.missingKey;
+            return;
+        }
+        decrypt(key, el.dataset.ciphertext).then(function (text) {
+            target.textContent = text;
+            target.hidden = false;
+            status.hidden = true;
+        }, function () {
+            status.textContent = el.dataset.failed;
+        });
+    }
+
+    var canEncrypt = window.crypto && crypto.subtle && window.TextEncoder;
+    var option = document.querySelector("[data-e2e-option]");
+    if (option) {
+        if (canEncrypt) {
+            setUpForm(option);
+        }
+    } else {
+        carryKey();
+    }
+    var snippet = document.querySelector("[data-e2e-snippet]");
+    if (snippet) {
+        if (canEncrypt) {
+            showSnippet(snippet);
+        } else {
+            snippet.querySelector("[data-e2e-status]").textContent = snippet.dataset.unsupported;
+        }
+    }
+})();